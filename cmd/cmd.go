@@ -21,8 +21,12 @@ func buildTailoutCommand(app *tailout.App) *cobra.Command {
 
 	cmd.AddCommand(buildCreateCommand(app))
 	cmd.AddCommand(buildDisconnectCommand(app))
+	cmd.AddCommand(buildExtendCommand(app))
+	cmd.AddCommand(buildGCCommand(app))
 	cmd.AddCommand(buildConnectCommand(app))
+	cmd.AddCommand(buildImageCommand(app))
 	cmd.AddCommand(buildInitCommand(app))
+	cmd.AddCommand(buildProxyCommand(app))
 	cmd.AddCommand(buildStatusCommand(app))
 	cmd.AddCommand(buildStopCommand(app))
 	cmd.AddCommand(buildUICommand(app))
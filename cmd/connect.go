@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/lucacome/tailout/tailout"
 	"github.com/spf13/cobra"
@@ -11,8 +13,26 @@ import (
 func buildConnectCommand(app *tailout.App) *cobra.Command {
 	cmd := &cobra.Command{
 		Args:  cobra.ArbitraryArgs,
-		Use:   "connect",
+		Use:   "connect [node]",
 		Short: "Connect to an exit node in your tailnet",
+		Long: `Connect to an exit node in your tailnet.
+
+	The node is designated by its hostname, MagicDNS name, instance ID, tailnet IP address, region or a unique prefix.
+
+	With --region, connect to a healthy tailout node of the region, or create one there if there is none. The flags of
+	the create command configure the new node.
+
+	With --best, connect to the node Tailscale suggests as exit node if it is a tailout node, or else to the node with
+	the lowest latency. The interactive list is sorted by latency.
+
+	With --watch, keep running and fail over to another healthy tailout node when the exit node goes offline, creating
+	one with --watch-create if there is none left. Ctrl-C disconnects as the disconnect command does.
+
+	The LAN access and DNS settings are applied along with the exit node, and reverted by the disconnect command.
+
+	With --embedded, tailout runs its own ephemeral Tailscale node, which uses the exit node until tailout exits.
+
+	Example : tailout connect --region eu-central-1`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			err := app.Connect(cmd.Context(), args)
 			if err != nil {
@@ -24,7 +44,62 @@ func buildConnectCommand(app *tailout.App) *cobra.Command {
 
 	cmd.PersistentFlags().BoolVarP(&app.Config.NonInteractive, "non-interactive", "n", false, "Disable interactive prompts")
 	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.APIKey, "tailscale-api-key", "", "Tailscale API key used to perform operations on your tailnet")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientID, "tailscale-oauth-client-id", "", "Tailscale OAuth client ID, used instead of the API key")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientSecret, "tailscale-oauth-client-secret", "", "Tailscale OAuth client secret")
 	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.BaseURL, "tailscale-base-url", "https://api.tailscale.com", "Tailscale base API URL, change this if you are using Headscale")
+	cmd.PersistentFlags().StringVar(&app.Config.Provider, "provider", "aws", "Cloud provider used to manage tailout nodes")
+	addEgressFlags(cmd, app)
+	addEmbeddedFlags(cmd, app)
+	cmd.PersistentFlags().StringVarP(&app.Config.Connect.Region, "region", "r", "", "Connect to a node of this region, created if there is none")
+
+	cmd.PersistentFlags().BoolVar(&app.Config.Connect.Best, "best", false, "Connect to the tailout node with the lowest latency")
+
+	cmd.PersistentFlags().BoolVarP(&app.Config.Connect.Watch, "watch", "w", false, "Keep running and fail over to another tailout node when the exit node goes offline")
+	cmd.PersistentFlags().DurationVar(&app.Config.Connect.WatchInterval, "watch-interval", 10*time.Second, "Interval between two checks of the exit node in watch mode")
+	cmd.PersistentFlags().BoolVar(&app.Config.Connect.WatchCreate, "watch-create", false, "Create a node when there is no healthy tailout node to fail over to in watch mode")
+
+	addExitNodeFlags(cmd, app)
+	addCreateFlags(cmd, app)
 
 	return cmd
 }
+
+// addExitNodeFlags adds the flags of the client preferences applied along
+// with the exit node, shared by the commands that can connect.
+func addExitNodeFlags(cmd *cobra.Command, app *tailout.App) {
+	addOptionalBoolFlag(cmd, &app.Config.Connect.ExitNodeAllowLANAccess, "exit-node-allow-lan-access", "Allow direct access to the local network while using the exit node, the current setting is kept if not set")
+	addOptionalBoolFlag(cmd, &app.Config.Connect.AcceptDNS, "accept-dns", "Use the DNS configuration of the tailnet while using the exit node, the current setting is kept if not set")
+	setConfigSection(cmd, "connect", "exit-node-allow-lan-access", "accept-dns")
+}
+
+// addOptionalBoolFlag adds a boolean flag that only sets target when it is
+// given, so that an unset flag can be told apart from false.
+func addOptionalBoolFlag(cmd *cobra.Command, target **bool, name string, usage string) {
+	flag := cmd.PersistentFlags().VarPF(optionalBool{target: target}, name, "", usage)
+	flag.NoOptDefVal = "true"
+}
+
+// optionalBool is a pflag.Value setting a *bool.
+type optionalBool struct {
+	target **bool
+}
+
+func (b optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q: %w", s, err)
+	}
+	*b.target = &v
+	return nil
+}
+
+func (b optionalBool) String() string {
+	if b.target == nil || *b.target == nil {
+		return ""
+	}
+	return strconv.FormatBool(**b.target)
+}
+
+func (b optionalBool) Type() string {
+	return "bool"
+}
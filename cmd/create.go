@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/lucacome/tailout/tailout"
+	"github.com/lucacome/tailout/tailout/config"
 	"github.com/spf13/cobra"
 )
 
@@ -17,11 +18,12 @@ func buildCreateCommand(app *tailout.App) *cobra.Command {
 
  This command will create an EC2 instance in the targeted region with the following configuration:
  - Amazon Linux 2 AMI
- - t3a.micro instance type
- - Tailscale installed and configured to advertise as an exit node
+ - t3a.micro instance type by default, arm64 (Graviton) instance types use the arm64 AMI
+ - Tailscale installed and configured to advertise as an exit node, with SSM by default or from the user data with --bootstrap user-data
  - SSH access enabled
  - Tagged with App=tailout
- - The instance will be created as a spot instance in the default VPC`,
+ - The instance will be created as a spot instance in the default VPC, use --market to change the purchasing option
+   and --vpc-id, --subnet-id, --security-group-ids or --dedicated-network to change the network`,
 
 		RunE: func(cmd *cobra.Command, _ []string) error {
 			err := app.Create(cmd.Context())
@@ -33,13 +35,48 @@ func buildCreateCommand(app *tailout.App) *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.APIKey, "tailscale-api-key", "", "Tailscale API key used to perform operations on your tailnet")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientID, "tailscale-oauth-client-id", "", "Tailscale OAuth client ID, used instead of the API key")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientSecret, "tailscale-oauth-client-secret", "", "Tailscale OAuth client secret")
 	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.BaseURL, "tailscale-base-url", "https://api.tailscale.com", "Tailscale base API URL, change this if you are using Headscale")
+	cmd.PersistentFlags().StringVar(&app.Config.Provider, "provider", "aws", "Cloud provider used to manage tailout nodes")
 	cmd.PersistentFlags().BoolVarP(&app.Config.DryRun, "dry-run", "d", false, "Dry run mode (no changes will be made)")
 	cmd.PersistentFlags().BoolVarP(&app.Config.NonInteractive, "non-interactive", "n", false, "Disable interactive prompts")
 	cmd.PersistentFlags().StringVarP(&app.Config.Region, "region", "r", "", "Cloud-provider region to use")
 
-	cmd.PersistentFlags().StringVarP(&app.Config.Create.Shutdown, "shutdown", "s", "2h", "Shutdown the instance after the specified duration. Valid time units are \"s\", \"m\", \"h\"")
 	cmd.PersistentFlags().BoolVarP(&app.Config.Create.Connect, "connect", "c", false, "Connect to the instance after creation")
+	addExitNodeFlags(cmd, app)
+	addCreateFlags(cmd, app)
 
 	return cmd
 }
+
+// addCreateFlags adds the flags that configure new nodes, shared by the
+// commands that can create one.
+func addCreateFlags(cmd *cobra.Command, app *tailout.App) {
+	cmd.PersistentFlags().StringVarP(&app.Config.Create.Shutdown, "shutdown", "s", config.DefaultShutdown, "Shutdown the instance after the specified duration. Valid time units are \"s\", \"m\", \"h\". Use \"none\" to keep the instance running until it is stopped")
+	cmd.PersistentFlags().StringVar(&app.Config.Create.Bootstrap, "bootstrap", config.DefaultBootstrap, "How Tailscale is installed on the instance: ssm, which requires the SSM agent and permissions, or user-data")
+	cmd.PersistentFlags().StringVar(&app.Config.Create.Market, "market", config.DefaultMarket, "Purchasing option of the instance: spot, on-demand or spot-then-on-demand")
+	cmd.PersistentFlags().StringVar(&app.Config.Create.SpotMaxPrice, "spot-max-price", "", "Maximum hourly price of a spot instance, defaults to the on-demand price")
+	cmd.PersistentFlags().StringVarP(&app.Config.Create.InstanceType, "instance-type", "t", config.DefaultInstanceType, "Instance type of the node, the image architecture is derived from it")
+	setConfigSection(cmd, "create", "shutdown", "bootstrap", "market", "spot-max-price", "instance-type")
+	addNetworkFlags(cmd, app)
+}
+
+// addNetworkFlags adds the flags that select the network instances are
+// launched in, shared by the commands that launch one.
+func addNetworkFlags(cmd *cobra.Command, app *tailout.App) {
+	cmd.PersistentFlags().StringVar(&app.Config.Create.VPCID, "vpc-id", "", "VPC to create the instance in, a subnet of the VPC is picked if no subnet is specified")
+	cmd.PersistentFlags().StringVar(&app.Config.Create.SubnetID, "subnet-id", "", "Subnet to create the instance in")
+	cmd.PersistentFlags().StringSliceVar(&app.Config.Create.SecurityGroupIDs, "security-group-ids", nil, "Security groups to attach to the instance")
+	cmd.PersistentFlags().BoolVar(&app.Config.Create.DedicatedNetwork, "dedicated-network", false, "Create the instance in a tailout-owned VPC that only allows inbound Tailscale traffic, created if it does not exist")
+	setConfigSection(cmd, "create", "vpc-id", "subnet-id", "security-group-ids", "dedicated-network")
+}
+
+// setConfigSection binds the flags to the keys of the configuration section,
+// whatever the command they are given to.
+func setConfigSection(cmd *cobra.Command, section string, names ...string) {
+	for _, name := range names {
+		// The flags were just added, so the annotation cannot fail.
+		_ = cmd.PersistentFlags().SetAnnotation(name, config.SectionAnnotation, []string{section})
+	}
+}
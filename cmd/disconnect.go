@@ -13,6 +13,10 @@ func buildDisconnectCommand(app *tailout.App) *cobra.Command {
 		Args:  cobra.NoArgs,
 		Use:   "disconnect",
 		Short: "Disconnect from an exit node in your tailnet",
+		Long: `Disconnect from an exit node in your tailnet.
+
+	The exit node settings that were active before connect, such as a corporate or Mullvad exit node, are restored. Use
+	--clear to clear the exit node instead.`,
 		RunE: func(cmd *cobra.Command, _ []string) error {
 			err := app.Disconnect(cmd.Context())
 			if err != nil {
@@ -24,5 +28,7 @@ func buildDisconnectCommand(app *tailout.App) *cobra.Command {
 
 	cmd.PersistentFlags().BoolVarP(&app.Config.NonInteractive, "non-interactive", "n", false, "Disable interactive prompts")
 
+	cmd.PersistentFlags().BoolVar(&app.Config.Disconnect.Clear, "clear", false, "Clear the exit node instead of restoring the one active before connect")
+
 	return cmd
 }
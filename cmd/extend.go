@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/lucacome/tailout/tailout"
+	"github.com/spf13/cobra"
+)
+
+func buildExtendCommand(app *tailout.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "extend <node name> [duration]",
+		Args:  cobra.RangeArgs(1, 2),
+		Short: "Change the shutdown deadline of a running node",
+		Long: `Change the shutdown deadline of a running node.
+
+	By default, the duration is added to the current deadline of the node. Use --shorten to bring the deadline closer,
+	or --at to set an absolute deadline. Valid time units are "s", "m", "h". The node is selected like with connect.
+
+	The deadline is changed with SSM Run Command, so nodes created with --bootstrap user-data in accounts where SSM
+	is not set up cannot be extended.
+
+	Example : tailout extend tailout-eu-west-3-k3v9q2xa 1h`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := app.Extend(cmd.Context(), args)
+			if err != nil {
+				return fmt.Errorf("failed to extend node: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.APIKey, "tailscale-api-key", "", "Tailscale API key used to perform operations on your tailnet")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientID, "tailscale-oauth-client-id", "", "Tailscale OAuth client ID, used instead of the API key")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientSecret, "tailscale-oauth-client-secret", "", "Tailscale OAuth client secret")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.BaseURL, "tailscale-base-url", "https://api.tailscale.com", "Tailscale base API URL, change this if you are using Headscale")
+	cmd.PersistentFlags().StringVar(&app.Config.Provider, "provider", "aws", "Cloud provider used to manage tailout nodes")
+	cmd.PersistentFlags().BoolVarP(&app.Config.DryRun, "dry-run", "d", false, "Dry run mode (no changes will be made)")
+	cmd.PersistentFlags().BoolVar(&app.Config.Extend.Shorten, "shorten", false, "Subtract the duration from the current deadline instead of adding it")
+	cmd.PersistentFlags().StringVar(&app.Config.Extend.At, "at", "", "Set the deadline to an absolute time, in RFC 3339 or HH:MM (local time) format")
+
+	return cmd
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/lucacome/tailout/tailout"
+	"github.com/lucacome/tailout/tailout/config"
+	"github.com/spf13/cobra"
+)
+
+func buildGCCommand(app *tailout.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Args:  cobra.NoArgs,
+		Use:   "gc",
+		Short: "Clean up orphaned tailout resources",
+		Long: `Clean up orphaned tailout resources.
+
+	This command will find and remove:
+	- instances tagged App=tailout that have no device in your tailnet,
+	- devices tagged tag:tailout that have no instance behind them,
+	- unused auth keys created by tailout.
+
+	Resources created more recently than the grace period are ignored, as they may belong to a node that is still being created.
+
+	Example : tailout gc --dry-run`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			err := app.GC(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to clean up resources: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.APIKey, "tailscale-api-key", "", "Tailscale API key used to perform operations on your tailnet")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientID, "tailscale-oauth-client-id", "", "Tailscale OAuth client ID, used instead of the API key")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientSecret, "tailscale-oauth-client-secret", "", "Tailscale OAuth client secret")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.BaseURL, "tailscale-base-url", "https://api.tailscale.com", "Tailscale base API URL, change this if you are using Headscale")
+	cmd.PersistentFlags().StringVar(&app.Config.Provider, "provider", "aws", "Cloud provider used to manage tailout nodes")
+	cmd.PersistentFlags().BoolVarP(&app.Config.NonInteractive, "non-interactive", "n", false, "Disable interactive prompts")
+	cmd.PersistentFlags().BoolVarP(&app.Config.DryRun, "dry-run", "d", false, "Dry run mode (no changes will be made)")
+	cmd.PersistentFlags().StringVar(&app.Config.GC.GracePeriod, "grace-period", config.DefaultGCGracePeriod, "Ignore resources created more recently than this duration")
+
+	return cmd
+}
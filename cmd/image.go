@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/lucacome/tailout/tailout"
+	"github.com/lucacome/tailout/tailout/config"
+	"github.com/spf13/cobra"
+)
+
+func buildImageCommand(app *tailout.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "image",
+		Short: "Manage the images tailout nodes are created from",
+		Long: `Manage the images tailout nodes are created from.
+
+	Images built by tailout have Tailscale installed and tuned for exit nodes, so that nodes created from them join the
+	tailnet faster. Create uses the newest tailout image of the region for the architecture of the instance type, and
+	falls back to the latest Amazon Linux 2023 image when there is none.`,
+	}
+
+	cmd.PersistentFlags().StringVar(&app.Config.Provider, "provider", "aws", "Cloud provider used to manage tailout nodes")
+	cmd.PersistentFlags().StringVarP(&app.Config.Region, "region", "r", "", "Cloud-provider region to use")
+	cmd.PersistentFlags().BoolVarP(&app.Config.NonInteractive, "non-interactive", "n", false, "Disable interactive prompts")
+
+	cmd.AddCommand(buildImageBuildCommand(app))
+	cmd.AddCommand(buildImageListCommand(app))
+	cmd.AddCommand(buildImagePruneCommand(app))
+
+	return cmd
+}
+
+func buildImageBuildCommand(app *tailout.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Args:  cobra.NoArgs,
+		Use:   "build",
+		Short: "Build an image with Tailscale installed",
+		Long: `Build an image with Tailscale installed.
+
+	This command launches a builder instance, installs Tailscale on it with SSM, creates an image tagged App=tailout
+	from it and terminates the builder instance. The architecture of the image is the one of the instance type.
+
+	Example : tailout image build --region eu-west-3 --instance-type t4g.micro`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			err := app.ImageBuild(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to build image: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&app.Config.Image.InstanceType, "instance-type", "t", config.DefaultInstanceType, "Instance type of the builder, the image architecture is derived from it")
+	addNetworkFlags(cmd, app)
+
+	return cmd
+}
+
+func buildImageListCommand(app *tailout.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Args:  cobra.NoArgs,
+		Use:   "list",
+		Short: "List the images built by tailout",
+		Long: `List the images built by tailout, in the given region or in every region.
+
+	Example : tailout image list`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			err := app.ImageList(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to list images: %w", err)
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func buildImagePruneCommand(app *tailout.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Args:  cobra.NoArgs,
+		Use:   "prune",
+		Short: "Delete old images built by tailout",
+		Long: `Delete old images built by tailout, in the given region or in every region.
+
+	The newest images of each region and architecture are kept.
+
+	Example : tailout image prune --keep 2`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			err := app.ImagePrune(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to prune images: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&app.Config.DryRun, "dry-run", "d", false, "Dry run mode (no changes will be made)")
+	cmd.Flags().IntVar(&app.Config.Image.Keep, "keep", 1, "Number of images to keep for each region and architecture")
+
+	return cmd
+}
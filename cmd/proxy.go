@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/lucacome/tailout/tailout"
+	"github.com/spf13/cobra"
+)
+
+func buildProxyCommand(app *tailout.App) *cobra.Command {
+	cmd := &cobra.Command{
+		Args:  cobra.MaximumNArgs(1),
+		Use:   "proxy [node]",
+		Short: "Start a local proxy that egresses through a tailout node",
+		Long: `Start a local SOCKS5 and HTTP proxy that egresses through a tailout node.
+
+	The proxy connections are dialed by an embedded Tailscale node that uses the tailout node as its exit node, so the
+	exit node of this machine is left untouched. The node is designated as in the connect command, the node with the
+	lowest latency is used if none is given. Set --socks-address or --http-address to an empty value to disable a proxy.
+
+	Example : tailout proxy tailout-eu-west-3 --socks-address 127.0.0.1:1080`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := app.Proxy(cmd.Context(), args)
+			if err != nil {
+				return fmt.Errorf("failed to run proxy: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.APIKey, "tailscale-api-key", "", "Tailscale API key used to perform operations on your tailnet")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientID, "tailscale-oauth-client-id", "", "Tailscale OAuth client ID, used instead of the API key")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientSecret, "tailscale-oauth-client-secret", "", "Tailscale OAuth client secret")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.BaseURL, "tailscale-base-url", "https://api.tailscale.com", "Tailscale base API URL, change this if you are using Headscale")
+	cmd.PersistentFlags().StringVar(&app.Config.Provider, "provider", "aws", "Cloud provider used to manage tailout nodes")
+	cmd.PersistentFlags().StringVar(&app.Config.Proxy.SocksAddress, "socks-address", "127.0.0.1:1080", "Address the SOCKS5 proxy listens on")
+	cmd.PersistentFlags().StringVar(&app.Config.Proxy.HTTPAddress, "http-address", "127.0.0.1:8118", "Address the HTTP proxy listens on")
+	cmd.PersistentFlags().StringVar(&app.Config.Embedded.Dir, "embedded-dir", "", "State directory of the embedded node, defaults to tailout/tsnet in the user cache directory")
+
+	return cmd
+}
@@ -15,6 +15,7 @@ func buildStatusCommand(app *tailout.App) *cobra.Command {
 		Long: `Show tailout-related informations.
 
 		This command will show the status of tailout nodes, including the node name and whether it is connected or not.
+		When connected, it checks that the public IP address of this machine is the one of the exit node.
 
 		Example : tailout status`,
 		RunE: func(cmd *cobra.Command, _ []string) error {
@@ -27,7 +28,27 @@ func buildStatusCommand(app *tailout.App) *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.APIKey, "tailscale-api-key", "", "Tailscale API key used to perform operations on your tailnet")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientID, "tailscale-oauth-client-id", "", "Tailscale OAuth client ID, used instead of the API key")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientSecret, "tailscale-oauth-client-secret", "", "Tailscale OAuth client secret")
 	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.BaseURL, "tailscale-base-url", "https://api.tailscale.com", "Tailscale base API URL, change this if you are using Headscale")
+	cmd.PersistentFlags().StringVar(&app.Config.Provider, "provider", "aws", "Cloud provider used to manage tailout nodes")
+	cmd.PersistentFlags().BoolVar(&app.Config.Status.IncludeBuilders, "include-builders", false, "Also show the builder instances of image builds")
+	addEgressFlags(cmd, app)
+	addEmbeddedFlags(cmd, app)
 
 	return cmd
 }
+
+// addEgressFlags adds the flags that configure how the egress through the
+// exit node is verified.
+func addEgressFlags(cmd *cobra.Command, app *tailout.App) {
+	cmd.PersistentFlags().StringVar(&app.Config.Egress.EchoURL, "echo-url", "https://ifconfig.me/ip", "URL of the service returning the public IP address of this machine, can be self-hosted")
+	cmd.PersistentFlags().BoolVar(&app.Config.Egress.NodeEcho, "node-echo", false, "Compare the public IP address with the one the exit node reports over the tailnet instead of the one of its instance")
+}
+
+// addEmbeddedFlags adds the flags of the embedded mode, in which tailout runs
+// its own Tailscale node instead of using the local tailscaled.
+func addEmbeddedFlags(cmd *cobra.Command, app *tailout.App) {
+	cmd.PersistentFlags().BoolVar(&app.Config.Embedded.Enabled, "embedded", false, "Run an embedded Tailscale node instead of using the local tailscaled, it leaves the tailnet when tailout exits")
+	cmd.PersistentFlags().StringVar(&app.Config.Embedded.Dir, "embedded-dir", "", "State directory of the embedded node, defaults to tailout/tsnet in the user cache directory")
+}
@@ -9,14 +9,19 @@ import (
 
 func buildStopCommand(app *tailout.App) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "stop [node names...]",
+		Use:   "stop [nodes...]",
 		Args:  cobra.ArbitraryArgs,
 		Short: "Terminates instances created by tailout",
 		Long: `By default, terminates all instances created by tailout.
 
-	If one or more Node names are specified, only those instances will be terminated.
+	If one or more nodes are specified, only those instances will be terminated. A node is designated by its hostname,
+	MagicDNS name, instance ID, tailnet IP address, region, a glob pattern such as "tailout-eu-*" or a unique prefix.
+	Every node specified must match at least one instance.
 
-	Example : tailout stop tailout-eu-west-3-i-048afd4880f66c596`,
+	Persistent nodes, created with --shutdown none, are skipped by --all unless --include-persistent is set.
+	The builder instances of running image builds are left alone unless --include-builders is set.
+
+	Example : tailout stop tailout-eu-west-3-k3v9q2xa i-0a1b us-east-1`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			err := app.Stop(cmd.Context(), args)
 			if err != nil {
@@ -27,10 +32,16 @@ func buildStopCommand(app *tailout.App) *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.APIKey, "tailscale-api-key", "", "Tailscale API key used to perform operations on your tailnet")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientID, "tailscale-oauth-client-id", "", "Tailscale OAuth client ID, used instead of the API key")
+	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.OAuthClientSecret, "tailscale-oauth-client-secret", "", "Tailscale OAuth client secret")
 	cmd.PersistentFlags().StringVar(&app.Config.Tailscale.BaseURL, "tailscale-base-url", "https://api.tailscale.com", "Tailscale base API URL, change this if you are using Headscale")
+	cmd.PersistentFlags().StringVar(&app.Config.Provider, "provider", "aws", "Cloud provider used to manage tailout nodes")
 	cmd.PersistentFlags().BoolVarP(&app.Config.NonInteractive, "non-interactive", "n", false, "Disable interactive prompts")
 	cmd.PersistentFlags().BoolVarP(&app.Config.DryRun, "dry-run", "d", false, "Dry run mode (no changes will be made)")
 	cmd.PersistentFlags().BoolVarP(&app.Config.Stop.All, "all", "a", false, "Terminate all instances created by tailout")
+	cmd.PersistentFlags().BoolVar(&app.Config.Stop.IncludePersistent, "include-persistent", false, "Also terminate the persistent instances when using --all")
+	cmd.PersistentFlags().BoolVar(&app.Config.Stop.IncludeBuilders, "include-builders", false, "Also terminate the builder instances of image builds")
+	cmd.PersistentFlags().BoolVar(&app.Config.Stop.Expired, "expired", false, "Terminate the instances that are still running after their shutdown deadline")
 
 	return cmd
 }
@@ -23,6 +23,7 @@ func buildUICommand(app *tailout.App) *cobra.Command {
 	}
 
 	cmd.PersistentFlags().BoolVarP(&app.Config.NonInteractive, "non-interactive", "n", false, "Disable interactive prompts")
+	cmd.PersistentFlags().StringVar(&app.Config.Provider, "provider", "aws", "Cloud provider used to manage tailout nodes")
 	cmd.PersistentFlags().StringVarP(&app.Config.UI.Address, "address", "a", "127.0.0.1", "Address to bind the UI to")
 	cmd.PersistentFlags().StringVarP(&app.Config.UI.Port, "port", "p", "3000", "Port to bind the UI to")
 
@@ -4,45 +4,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"sort"
-	"time"
+	"slices"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/charmbracelet/huh"
+	"github.com/lucacome/tailout/tailout/provider"
 	tslocal "tailscale.com/client/local"
 	tsapi "tailscale.com/client/tailscale/v2"
 	"tailscale.com/ipn"
 	"tailscale.com/tailcfg"
 )
 
-func GetRegions(ctx context.Context) ([]string, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
+func GetRegions(ctx context.Context, p provider.Provider) ([]string, error) {
+	regionNames, err := p.Regions(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load default config: %w", err)
+		return nil, fmt.Errorf("failed to list %s regions: %w", p.Name(), err)
 	}
-	ec2Svc := ec2.NewFromConfig(cfg)
-
-	regions, err := ec2Svc.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to describe regions: %w", err)
-	}
-
-	regionNames := []string{}
-	for _, region := range regions.Regions {
-		regionNames = append(regionNames, *region.RegionName)
-	}
-
-	sort.Slice(regionNames, func(i, j int) bool {
-		return regionNames[i] < regionNames[j]
-	})
 
 	return regionNames, nil
 }
 
-// Function that uses huh to return an AWS region fetched from the aws sdk.
-func SelectRegion(ctx context.Context) (string, error) {
-	regionNames, err := GetRegions(ctx)
+// Function that uses huh to return a region fetched from the cloud provider.
+func SelectRegion(ctx context.Context, p provider.Provider) (string, error) {
+	regionNames, err := GetRegions(ctx, p)
 	if err != nil {
 		if ctx.Err() != nil {
 			return "", fmt.Errorf("operation canceled: %w", ctx.Err())
@@ -94,29 +77,27 @@ func PromptYesNo(ctx context.Context, question string) (bool, error) {
 	return confirm, nil
 }
 
-func GetActiveNodes(ctx context.Context, c *tsapi.Client) ([]tsapi.Device, error) {
-	devices, err := c.Devices().List(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get devices: %w", err)
-	}
-
-	tailoutDevices := make([]tsapi.Device, 0)
-	for _, device := range devices {
-		for _, tag := range device.Tags {
-			if tag == "tag:tailout" {
-				if time.Duration(device.LastSeen.Minute()) < 10*time.Minute {
-					tailoutDevices = append(tailoutDevices, device)
-				}
-			}
-		}
-	}
-
-	return tailoutDevices, nil
+// ExitNodeOptions are the client preferences applied along with the exit node.
+// A nil preference is left as it is.
+type ExitNodeOptions struct {
+	// AllowLANAccess keeps the local network reachable while using the exit node.
+	AllowLANAccess *bool
+	// AcceptDNS uses the DNS configuration of the tailnet.
+	AcceptDNS *bool
+	// SkipState does not save the exit node settings to restore on
+	// disconnect, for nodes that do not outlive tailout such as the
+	// embedded one.
+	SkipState bool
 }
 
-func UpdateExitNode(ctx context.Context, c *tsapi.Client, id string) error {
-	var localClient tslocal.Client
-
+// UpdateExitNode sets the exit node and its options in the same preferences
+// edit, or clears the exit node if id is empty. The options are left
+// untouched when clearing.
+//
+// The previous settings are saved to be restored on disconnect, unless the
+// current exit node is already a tailout node. If setting the exit node
+// fails, they are restored right away and the saved state is deleted.
+func UpdateExitNode(ctx context.Context, localClient *tslocal.Client, c *tsapi.Client, id string, opts ExitNodeOptions) (err error) {
 	status, err := localClient.Status(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get tailscale status: %w", err)
@@ -127,6 +108,7 @@ func UpdateExitNode(ctx context.Context, c *tsapi.Client, id string) error {
 	}
 
 	var currentExitNodeName string
+	currentIsTailout := false
 	if status.ExitNodeStatus != nil {
 		// Get all devices to find the current exit node name
 		devices, errList := c.Devices().List(ctx)
@@ -138,6 +120,7 @@ func UpdateExitNode(ctx context.Context, c *tsapi.Client, id string) error {
 		for _, device := range devices {
 			if device.NodeID == string(status.ExitNodeStatus.ID) {
 				currentExitNodeName = device.Name
+				currentIsTailout = slices.Contains(device.Tags, "tag:tailout")
 				break
 			}
 		}
@@ -152,20 +135,48 @@ func UpdateExitNode(ctx context.Context, c *tsapi.Client, id string) error {
 		return fmt.Errorf("failed to get prefs: %w", err)
 	}
 
+	if id != "" && !opts.SkipState && !currentIsTailout {
+		state, saveErr := saveExitNodeState(prefs)
+		if saveErr != nil {
+			return fmt.Errorf("failed to save the current exit node: %w", saveErr)
+		}
+		defer func() {
+			if err != nil {
+				err = errors.Join(err, discardExitNodeState(ctx, localClient, state))
+			}
+		}()
+	}
 	if id != "" {
+		if prefs.AutoExitNode.IsSet() {
+			fmt.Printf("Replacing automatic exit node selection (%s)...\n", prefs.AutoExitNode)
+		}
 		fmt.Printf("Setting exit node to %s...\n", id)
+		prefs.ClearExitNode()
 		prefs.ExitNodeID = tailcfg.StableNodeID(id)
+		if opts.AllowLANAccess != nil {
+			prefs.ExitNodeAllowLANAccess = *opts.AllowLANAccess
+		}
+		if opts.AcceptDNS != nil {
+			prefs.CorpDNS = *opts.AcceptDNS
+		}
 	} else {
 		fmt.Println("Clearing exit node...")
 		prefs.ClearExitNode()
 	}
-	_, err = localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
-		Prefs:         *prefs,
-		ExitNodeIDSet: true,
+	newPrefs, err := localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs:                     *prefs,
+		ExitNodeIDSet:             true,
+		ExitNodeIPSet:             true,
+		AutoExitNodeSet:           true,
+		ExitNodeAllowLANAccessSet: id != "" && opts.AllowLANAccess != nil,
+		CorpDNSSet:                id != "" && opts.AcceptDNS != nil,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to set/unset exit node: %w", err)
 	}
+	if id != "" {
+		fmt.Printf("Allow LAN access: %t, accept DNS: %t\n", newPrefs.ExitNodeAllowLANAccess, newPrefs.CorpDNS)
+	}
 
 	status, err = localClient.Status(ctx)
 	if err != nil {
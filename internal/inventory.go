@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucacome/tailout/tailout/provider"
+	tsapi "tailscale.com/client/tailscale/v2"
+)
+
+// Node is a tailout node, made of a cloud instance tagged App=tailout and the
+// tailnet device it joined as. Either side can be missing, for example when an
+// instance never joined the tailnet or when a device outlived its instance.
+type Node struct {
+	Instance *provider.Instance
+	Device   *tsapi.Device
+}
+
+// Hostname returns the tailnet hostname of the node, or the instance name if
+// the node has no device.
+func (n Node) Hostname() string {
+	switch {
+	case n.Device != nil:
+		return n.Device.Hostname
+	case n.Instance != nil && n.Instance.Name != "":
+		return n.Instance.Name
+	case n.Instance != nil:
+		return n.Instance.ID
+	default:
+		return ""
+	}
+}
+
+// Region returns the region of the node instance, if any.
+func (n Node) Region() string {
+	if n.Instance == nil {
+		return ""
+	}
+	return n.Instance.Region
+}
+
+// InstanceID returns the ID of the node instance, if any.
+func (n Node) InstanceID() string {
+	if n.Instance == nil {
+		return ""
+	}
+	return n.Instance.ID
+}
+
+// Address returns the first tailnet address of the node, if any.
+func (n Node) Address() string {
+	if n.Device == nil || len(n.Device.Addresses) == 0 {
+		return ""
+	}
+	return n.Device.Addresses[0]
+}
+
+// PublicIP returns the public IP address of the node instance, if any.
+func (n Node) PublicIP() string {
+	if n.Instance == nil {
+		return ""
+	}
+	return n.Instance.PublicIP
+}
+
+// ExpiresAt returns the time at which the node shuts itself down, and false
+// if the deadline of the node is unknown.
+func (n Node) ExpiresAt() (time.Time, bool) {
+	if n.Instance == nil {
+		return time.Time{}, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, n.Instance.Tags[provider.TagExpiresAt])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+// Expired reports whether the deadline of the node passed while its instance
+// is still running, for example because the shutdown job failed.
+func (n Node) Expired(now time.Time) bool {
+	expiresAt, ok := n.ExpiresAt()
+	return ok && n.Instance.State == "running" && expiresAt.Before(now)
+}
+
+// Persistent reports whether the node was created without a shutdown deadline.
+func (n Node) Persistent() bool {
+	return n.Instance != nil && n.Instance.Tags[provider.TagPersistent] == "true"
+}
+
+// ImageBuilder reports whether the node is a builder instance of a tailout image.
+func (n Node) ImageBuilder() bool {
+	return n.Instance != nil && n.Instance.Tags[provider.TagImageBuilder] == "true"
+}
+
+// WithoutImageBuilders returns the nodes that are not image builders.
+func WithoutImageBuilders(nodes []Node) []Node {
+	return slices.DeleteFunc(slices.Clone(nodes), Node.ImageBuilder)
+}
+
+// OnlineDevices returns the devices of the nodes that are connected to the
+// tailnet.
+func OnlineDevices(nodes []Node) []tsapi.Device {
+	devices := []tsapi.Device{}
+	for _, node := range nodes {
+		if node.Device != nil && node.Device.ConnectedToControl {
+			devices = append(devices, *node.Device)
+		}
+	}
+	return devices
+}
+
+// CreatedBy returns the user that created the node, if known.
+func (n Node) CreatedBy() string {
+	if n.Instance == nil {
+		return ""
+	}
+	return n.Instance.Tags[provider.TagCreatedBy]
+}
+
+// ErrPartialInventory is returned by GetInventory, along with the nodes of the
+// other regions, when the instances of some regions could not be listed.
+var ErrPartialInventory = errors.New("the tailout instances of some regions could not be listed")
+
+// GetInventory lists the tailout instances of every region in parallel and
+// joins them to the tailout devices of the tailnet. A region that cannot be
+// listed, for example an opt-in region that is not enabled, does not prevent
+// the others from being listed, see ErrPartialInventory.
+func GetInventory(ctx context.Context, p provider.Provider, c *tsapi.Client) ([]Node, error) {
+	regionNames, err := GetRegions(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg        sync.WaitGroup
+		instances = make([][]provider.Instance, len(regionNames))
+		errs      = make([]error, len(regionNames))
+	)
+	for i, region := range regionNames {
+		wg.Go(func() {
+			regionInstances, describeErr := p.Describe(ctx, region)
+			if describeErr != nil {
+				errs[i] = fmt.Errorf("failed to list instances in %s: %w", region, describeErr)
+				return
+			}
+			instances[i] = regionInstances
+		})
+	}
+
+	devices, err := c.Devices().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+
+	wg.Wait()
+
+	tailoutDevices := make([]tsapi.Device, 0)
+	for _, device := range devices {
+		if slices.Contains(device.Tags, "tag:tailout") {
+			tailoutDevices = append(tailoutDevices, device)
+		}
+	}
+
+	matched := make([]bool, len(tailoutDevices))
+	nodes := []Node{}
+	for _, regionInstances := range instances {
+		for _, instance := range regionInstances {
+			if instance.State == "terminated" {
+				continue
+			}
+			node := Node{Instance: &instance}
+			for i := range tailoutDevices {
+				if !matched[i] && DeviceMatchesInstance(tailoutDevices[i], instance) {
+					node.Device = &tailoutDevices[i]
+					matched[i] = true
+					break
+				}
+			}
+			nodes = append(nodes, node)
+		}
+	}
+
+	for i := range tailoutDevices {
+		if !matched[i] {
+			nodes = append(nodes, Node{Device: &tailoutDevices[i]})
+		}
+	}
+
+	slices.SortFunc(nodes, func(a, b Node) int {
+		return strings.Compare(a.Hostname(), b.Hostname())
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return nodes, fmt.Errorf("%w: %w", ErrPartialInventory, err)
+	}
+	return nodes, nil
+}
+
+// DeviceMatchesInstance reports whether the device is the one the instance
+// joined the tailnet as. Devices are matched by the Name tag of the instance,
+// which is their hostname, or by the instance ID the hostname of older nodes
+// contains. Both still hold when Tailscale de-duplicates a colliding MagicDNS
+// name with a numeric suffix.
+func DeviceMatchesInstance(device tsapi.Device, instance provider.Instance) bool {
+	magicDNS, _, _ := strings.Cut(device.Name, ".")
+	if instance.Name != "" && (device.Hostname == instance.Name || isDeduplicated(magicDNS, instance.Name)) {
+		return true
+	}
+	if instance.ID == "" {
+		return false
+	}
+	return strings.Contains(device.Hostname, instance.ID) || strings.Contains(magicDNS, instance.ID)
+}
+
+// isDeduplicated reports whether name is base, possibly followed by the
+// numeric suffix Tailscale adds to colliding names.
+func isDeduplicated(name, base string) bool {
+	suffix, ok := strings.CutPrefix(name, base)
+	if !ok {
+		return false
+	}
+	if suffix == "" {
+		return true
+	}
+	n, ok := strings.CutPrefix(suffix, "-")
+	return ok && n != "" && strings.Trim(n, "0123456789") == ""
+}
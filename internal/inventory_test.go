@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/lucacome/tailout/tailout/provider"
+	tsapi "tailscale.com/client/tailscale/v2"
+)
+
+func TestDeviceMatchesInstance(t *testing.T) {
+	t.Parallel()
+
+	instance := provider.Instance{ID: "i-048afd4880f66c596", Name: "tailout-eu-west-3-i-048afd4880f66c596"}
+
+	matching := []tsapi.Device{
+		{Hostname: "tailout-eu-west-3-i-048afd4880f66c596"},
+		// Tailscale de-duplicates colliding names with a numeric suffix.
+		{Hostname: "tailout-eu-west-3-i-048afd4880f66c596-1"},
+		{Hostname: "ip-172-31-0-10", Name: "tailout-eu-west-3-i-048afd4880f66c596-1.tail1234.ts.net"},
+	}
+	for _, device := range matching {
+		if !DeviceMatchesInstance(device, instance) {
+			t.Errorf("device %q (%s) does not match instance %s", device.Hostname, device.Name, instance.ID)
+		}
+	}
+
+	other := tsapi.Device{Hostname: "tailout-eu-west-3-i-0aaaaaaaaaaaaaaaa", Name: "tailout-eu-west-3-i-0aaaaaaaaaaaaaaaa.tail1234.ts.net"}
+	if DeviceMatchesInstance(other, instance) {
+		t.Errorf("device %q matches instance %s", other.Hostname, instance.ID)
+	}
+}
+
+func TestDeviceMatchesNamedInstance(t *testing.T) {
+	t.Parallel()
+
+	instance := provider.Instance{ID: "i-048afd4880f66c596", Name: "tailout-eu-west-3-k3v9q2xa"}
+
+	matching := []tsapi.Device{
+		{Hostname: "tailout-eu-west-3-k3v9q2xa", Name: "tailout-eu-west-3-k3v9q2xa.tail1234.ts.net"},
+		{Hostname: "ip-172-31-0-10", Name: "tailout-eu-west-3-k3v9q2xa-2.tail1234.ts.net"},
+	}
+	for _, device := range matching {
+		if !DeviceMatchesInstance(device, instance) {
+			t.Errorf("device %q (%s) does not match instance %s", device.Hostname, device.Name, instance.Name)
+		}
+	}
+
+	other := tsapi.Device{Hostname: "ip-172-31-0-11", Name: "tailout-eu-west-3-k3v9q2xa-b.tail1234.ts.net"}
+	if DeviceMatchesInstance(other, instance) {
+		t.Errorf("device %q (%s) matches instance %s", other.Hostname, other.Name, instance.Name)
+	}
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	tslocal "tailscale.com/client/local"
+	tsapi "tailscale.com/client/tailscale/v2"
+	"tailscale.com/tailcfg"
+)
+
+// pingTimeout bounds the time spent pinging a single device.
+const pingTimeout = 5 * time.Second
+
+// DeviceLatency is the round-trip time from this machine to a device.
+type DeviceLatency struct {
+	Device  tsapi.Device
+	Latency time.Duration
+	// Err is set if the device could not be pinged.
+	Err error
+}
+
+// String returns the latency in milliseconds, or "unreachable".
+func (d DeviceLatency) String() string {
+	if d.Err != nil {
+		return "unreachable"
+	}
+	return fmt.Sprintf("%dms", d.Latency.Milliseconds())
+}
+
+// MeasureLatencies pings the devices in parallel through the Tailscale
+// client, and returns them sorted by latency with unreachable devices last.
+// Devices are pinged with a disco ping, which does not involve the IP stacks,
+// and with a TSMP ping if that fails.
+func MeasureLatencies(ctx context.Context, localClient *tslocal.Client, devices []tsapi.Device) []DeviceLatency {
+	var (
+		wg        sync.WaitGroup
+		latencies = make([]DeviceLatency, len(devices))
+	)
+	for i, device := range devices {
+		wg.Go(func() {
+			latency, err := ping(ctx, localClient, device)
+			latencies[i] = DeviceLatency{Device: device, Latency: latency, Err: err}
+		})
+	}
+	wg.Wait()
+
+	slices.SortStableFunc(latencies, func(a, b DeviceLatency) int {
+		switch {
+		case a.Err != nil && b.Err != nil:
+			return 0
+		case a.Err != nil:
+			return 1
+		case b.Err != nil:
+			return -1
+		default:
+			return cmp.Compare(a.Latency, b.Latency)
+		}
+	})
+	return latencies
+}
+
+func ping(ctx context.Context, localClient *tslocal.Client, device tsapi.Device) (time.Duration, error) {
+	if len(device.Addresses) == 0 {
+		return 0, errors.New("device has no address")
+	}
+	ip, err := netip.ParseAddr(device.Addresses[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %s: %w", device.Addresses[0], err)
+	}
+
+	var errs []error
+	for _, pingType := range []tailcfg.PingType{tailcfg.PingDisco, tailcfg.PingTSMP} {
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		result, pingErr := localClient.Ping(pingCtx, ip, pingType)
+		cancel()
+		switch {
+		case pingErr != nil:
+			errs = append(errs, fmt.Errorf("%s ping failed: %w", pingType, pingErr))
+		case result.Err != "":
+			errs = append(errs, fmt.Errorf("%s ping failed: %s", pingType, result.Err))
+		default:
+			return time.Duration(result.LatencySeconds * float64(time.Second)), nil
+		}
+	}
+	return 0, errors.Join(errs...)
+}
+
+// SuggestedDevice returns the exit node the Tailscale client suggests,
+// if it is one of the given devices.
+func SuggestedDevice(ctx context.Context, localClient *tslocal.Client, devices []tsapi.Device) (tsapi.Device, bool) {
+	suggestion, err := localClient.SuggestExitNode(ctx)
+	if err != nil {
+		return tsapi.Device{}, false
+	}
+
+	i := slices.IndexFunc(devices, func(d tsapi.Device) bool {
+		return d.NodeID == string(suggestion.ID)
+	})
+	if i == -1 {
+		return tsapi.Device{}, false
+	}
+	return devices[i], true
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ErrNoMatch is returned when a selector matches no node.
+var ErrNoMatch = errors.New("no tailout node matches")
+
+// AmbiguousSelectorError is returned when a selector that must designate a
+// single node matches several.
+type AmbiguousSelectorError struct {
+	Selector string
+	Matches  []string
+}
+
+func (e *AmbiguousSelectorError) Error() string {
+	return fmt.Sprintf("%q is ambiguous, it matches %s", e.Selector, strings.Join(e.Matches, ", "))
+}
+
+// SelectNode returns the single node designated by the selector, see
+// SelectNodes for the accepted selectors. Glob patterns are not accepted.
+func SelectNode(nodes []Node, selector string) (Node, error) {
+	matches, err := matchSelector(nodes, selector, false)
+	if err != nil {
+		return Node{}, err
+	}
+	if len(matches) > 1 {
+		return Node{}, ambiguous(nodes, selector, matches)
+	}
+	return nodes[matches[0]], nil
+}
+
+// SelectNodes returns the nodes designated by the selectors, in inventory
+// order and without duplicates. A selector is, by order of precedence:
+//   - an exact hostname, MagicDNS name, instance ID or tailnet IP address
+//   - a region, which matches all its nodes
+//   - a glob pattern matched against hostnames and MagicDNS names
+//   - a prefix of a single hostname or instance ID
+//
+// Every selector must match at least one node.
+func SelectNodes(nodes []Node, selectors []string) ([]Node, error) {
+	selected := make([]bool, len(nodes))
+	var errs []error
+	for _, selector := range selectors {
+		matches, err := matchSelector(nodes, selector, true)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, i := range matches {
+			selected[i] = true
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	result := make([]Node, 0, len(nodes))
+	for i, node := range nodes {
+		if selected[i] {
+			result = append(result, node)
+		}
+	}
+	return result, nil
+}
+
+// matchSelector returns the indices of the nodes matching the selector.
+func matchSelector(nodes []Node, selector string, glob bool) ([]int, error) {
+	if selector == "" {
+		return nil, errors.New("empty node selector")
+	}
+
+	exact := matchingIndices(nodes, func(n Node) bool {
+		return n.Hostname() == selector || n.InstanceID() == selector ||
+			magicDNSMatches(n, selector) || addressMatches(n, selector)
+	})
+	if len(exact) > 0 {
+		return exact, nil
+	}
+
+	region := matchingIndices(nodes, func(n Node) bool {
+		return n.Region() == selector
+	})
+	if len(region) > 0 {
+		return region, nil
+	}
+
+	if strings.ContainsAny(selector, "*?[") {
+		if !glob {
+			return nil, fmt.Errorf("%w %q: patterns are not accepted here", ErrNoMatch, selector)
+		}
+		if _, err := path.Match(selector, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", selector, err)
+		}
+		matches := matchingIndices(nodes, func(n Node) bool {
+			return globMatches(selector, n.Hostname()) || (n.Device != nil && globMatches(selector, strings.TrimSuffix(n.Device.Name, ".")))
+		})
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%w %q", ErrNoMatch, selector)
+		}
+		return matches, nil
+	}
+
+	prefix := matchingIndices(nodes, func(n Node) bool {
+		return strings.HasPrefix(n.Hostname(), selector) ||
+			(n.InstanceID() != "" && strings.HasPrefix(n.InstanceID(), selector))
+	})
+	switch len(prefix) {
+	case 0:
+		return nil, fmt.Errorf("%w %q", ErrNoMatch, selector)
+	case 1:
+		return prefix, nil
+	default:
+		return nil, ambiguous(nodes, selector, prefix)
+	}
+}
+
+func matchingIndices(nodes []Node, match func(Node) bool) []int {
+	var indices []int
+	for i, node := range nodes {
+		if match(node) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+func ambiguous(nodes []Node, selector string, matches []int) error {
+	hostnames := make([]string, len(matches))
+	for i, idx := range matches {
+		hostnames[i] = nodes[idx].Hostname()
+	}
+	return &AmbiguousSelectorError{Selector: selector, Matches: hostnames}
+}
+
+// magicDNSMatches reports whether the selector is the MagicDNS name of the
+// node device, fully qualified or not.
+func magicDNSMatches(n Node, selector string) bool {
+	if n.Device == nil || n.Device.Name == "" {
+		return false
+	}
+	name := strings.TrimSuffix(n.Device.Name, ".")
+	selector = strings.TrimSuffix(selector, ".")
+	short, _, _ := strings.Cut(name, ".")
+	return selector == name || selector == short
+}
+
+// addressMatches reports whether the selector is one of the tailnet
+// addresses of the node device.
+func addressMatches(n Node, selector string) bool {
+	if n.Device == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(selector)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(n.Device.Addresses, func(a string) bool {
+		deviceAddr, err := netip.ParseAddr(a)
+		return err == nil && deviceAddr == addr
+	})
+}
+
+func globMatches(pattern, name string) bool {
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/lucacome/tailout/tailout/provider"
+	tsapi "tailscale.com/client/tailscale/v2"
+)
+
+func testNodes() []Node {
+	node := func(region, id, magicDNS, addr string) Node {
+		hostname := "tailout-" + region + "-" + id
+		return Node{
+			Instance: &provider.Instance{ID: id, Name: hostname, Region: region},
+			Device: &tsapi.Device{
+				Hostname:  hostname,
+				Name:      magicDNS,
+				Addresses: []string{addr},
+			},
+		}
+	}
+	return []Node{
+		node("eu-west-3", "i-0aaa111", "tailout-eu-west-3-i-0aaa111.tail1234.ts.net", "100.64.0.1"),
+		node("eu-west-3", "i-0aaa222", "tailout-eu-west-3-i-0aaa222.tail1234.ts.net", "100.64.0.2"),
+		node("us-east-1", "i-0bbb333", "tailout-us-east-1-i-0bbb333.tail1234.ts.net", "100.64.0.3"),
+		// A node named like a region takes precedence over the region.
+		{Device: &tsapi.Device{Hostname: "us-east-1", Name: "us-east-1.tail1234.ts.net", Addresses: []string{"100.64.0.4"}}},
+	}
+}
+
+func hostnames(nodes []Node) []string {
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = node.Hostname()
+	}
+	return names
+}
+
+func TestSelectNode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		selector  string
+		want      string
+		wantErr   error
+		ambiguous bool
+	}{
+		{name: "hostname", selector: "tailout-eu-west-3-i-0aaa111", want: "tailout-eu-west-3-i-0aaa111"},
+		{name: "instance ID", selector: "i-0bbb333", want: "tailout-us-east-1-i-0bbb333"},
+		{name: "MagicDNS name", selector: "tailout-eu-west-3-i-0aaa222.tail1234.ts.net", want: "tailout-eu-west-3-i-0aaa222"},
+		{name: "fully qualified MagicDNS name", selector: "tailout-eu-west-3-i-0aaa222.tail1234.ts.net.", want: "tailout-eu-west-3-i-0aaa222"},
+		{name: "tailnet address", selector: "100.64.0.3", want: "tailout-us-east-1-i-0bbb333"},
+		{name: "exact match before region", selector: "us-east-1", want: "us-east-1"},
+		{name: "region with several nodes", selector: "eu-west-3", ambiguous: true},
+		{name: "unique hostname prefix", selector: "tailout-us", want: "tailout-us-east-1-i-0bbb333"},
+		{name: "unique instance ID prefix", selector: "i-0b", want: "tailout-us-east-1-i-0bbb333"},
+		{name: "ambiguous prefix", selector: "i-0aaa", ambiguous: true},
+		{name: "glob pattern", selector: "tailout-*", wantErr: ErrNoMatch},
+		{name: "no match", selector: "tailout-ap", wantErr: ErrNoMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			node, err := SelectNode(testNodes(), tt.selector)
+
+			var ambiguousErr *AmbiguousSelectorError
+			switch {
+			case tt.ambiguous:
+				if !errors.As(err, &ambiguousErr) {
+					t.Fatalf("SelectNode(%q) error = %v, want an AmbiguousSelectorError", tt.selector, err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("SelectNode(%q) error = %v, want %v", tt.selector, err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("SelectNode(%q) unexpected error: %v", tt.selector, err)
+			case node.Hostname() != tt.want:
+				t.Errorf("SelectNode(%q) = %s, want %s", tt.selector, node.Hostname(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectNodes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		selectors []string
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "region",
+			selectors: []string{"eu-west-3"},
+			want:      []string{"tailout-eu-west-3-i-0aaa111", "tailout-eu-west-3-i-0aaa222"},
+		},
+		{
+			name:      "glob pattern",
+			selectors: []string{"tailout-*-i-0aaa*"},
+			want:      []string{"tailout-eu-west-3-i-0aaa111", "tailout-eu-west-3-i-0aaa222"},
+		},
+		{
+			name:      "glob pattern on MagicDNS names",
+			selectors: []string{"*.tail1234.ts.net"},
+			want:      []string{"tailout-eu-west-3-i-0aaa111", "tailout-eu-west-3-i-0aaa222", "tailout-us-east-1-i-0bbb333", "us-east-1"},
+		},
+		{
+			name:      "overlapping selectors in inventory order without duplicates",
+			selectors: []string{"i-0bbb333", "eu-west-3", "tailout-eu-west-3-i-0aaa111"},
+			want:      []string{"tailout-eu-west-3-i-0aaa111", "tailout-eu-west-3-i-0aaa222", "tailout-us-east-1-i-0bbb333"},
+		},
+		{
+			name:      "exact match before region",
+			selectors: []string{"us-east-1"},
+			want:      []string{"us-east-1"},
+		},
+		{
+			name:      "ambiguous prefix",
+			selectors: []string{"tailout-eu"},
+			wantErr:   true,
+		},
+		{
+			name:      "one selector without match",
+			selectors: []string{"eu-west-3", "ap-south-1"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid pattern",
+			selectors: []string{"tailout-["},
+			wantErr:   true,
+		},
+		{
+			name:      "empty selector",
+			selectors: []string{""},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			nodes, err := SelectNodes(testNodes(), tt.selectors)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("SelectNodes(%q) = %v, want an error", tt.selectors, hostnames(nodes))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SelectNodes(%q) unexpected error: %v", tt.selectors, err)
+			}
+			if got := hostnames(nodes); !slices.Equal(got, tt.want) {
+				t.Errorf("SelectNodes(%q) = %v, want %v", tt.selectors, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	tslocal "tailscale.com/client/local"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// ExitNodeState holds the exit node preferences that were active before
+// tailout connected to one of its nodes, so that they can be restored.
+type ExitNodeState struct {
+	ExitNodeID             tailcfg.StableNodeID   `json:"exit_node_id,omitempty"`
+	ExitNodeIP             netip.Addr             `json:"exit_node_ip,omitzero"`
+	AutoExitNode           ipn.ExitNodeExpression `json:"auto_exit_node,omitempty"`
+	ExitNodeAllowLANAccess bool                   `json:"exit_node_allow_lan_access"`
+	CorpDNS                bool                   `json:"corp_dns"`
+}
+
+// stateFile returns the path of the file holding the exit node state, next
+// to the user configuration file.
+func stateFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, ".tailout", "state.json"), nil
+}
+
+// LoadExitNodeState returns the saved exit node state, and false if there is
+// none.
+func LoadExitNodeState() (ExitNodeState, bool, error) {
+	var state ExitNodeState
+
+	path, err := stateFile()
+	if err != nil {
+		return state, false, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, false, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return state, true, nil
+}
+
+// saveExitNodeState records the exit node preferences, replacing any state
+// left by an earlier connect, and returns the saved state.
+func saveExitNodeState(prefs *ipn.Prefs) (ExitNodeState, error) {
+	state := ExitNodeState{
+		ExitNodeID:             prefs.ExitNodeID,
+		ExitNodeIP:             prefs.ExitNodeIP,
+		AutoExitNode:           prefs.AutoExitNode,
+		ExitNodeAllowLANAccess: prefs.ExitNodeAllowLANAccess,
+		CorpDNS:                prefs.CorpDNS,
+	}
+
+	path, err := stateFile()
+	if err != nil {
+		return state, err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return state, fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return state, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return state, fmt.Errorf("failed to write state file: %w", err)
+	}
+	return state, nil
+}
+
+// discardExitNodeState restores the exit node preferences of the state after
+// a failed connect, and deletes the saved state.
+func discardExitNodeState(ctx context.Context, localClient *tslocal.Client, state ExitNodeState) error {
+	if err := RestoreExitNode(ctx, localClient, state); err != nil {
+		return err
+	}
+	return RemoveExitNodeState()
+}
+
+// RemoveExitNodeState deletes the saved exit node state, if any.
+func RemoveExitNodeState() error {
+	path, err := stateFile()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove state file: %w", err)
+	}
+	return nil
+}
+
+// RestoreExitNode applies the exit node preferences of the state.
+func RestoreExitNode(ctx context.Context, localClient *tslocal.Client, state ExitNodeState) error {
+	_, err := localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			ExitNodeID:             state.ExitNodeID,
+			ExitNodeIP:             state.ExitNodeIP,
+			AutoExitNode:           state.AutoExitNode,
+			ExitNodeAllowLANAccess: state.ExitNodeAllowLANAccess,
+			CorpDNS:                state.CorpDNS,
+		},
+		ExitNodeIDSet:             true,
+		ExitNodeIPSet:             true,
+		AutoExitNodeSet:           true,
+		ExitNodeAllowLANAccessSet: true,
+		CorpDNSSet:                true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore exit node: %w", err)
+	}
+	return nil
+}
@@ -47,7 +47,7 @@ func Index() templ.Component {
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "<h2 class=\"text-xl my-4 text-gray-600\">create an exit node in your tailnet in seconds.</h2><div><button id=\"create-btn\" class=\"bg-blue-500 hover:bg-blue-700 text-white font-bold py-2 px-4 mr-2 rounded\" hx-post=\"/create\" hx-indicator=\"#spinner\" hx-target=\"#create-btn\" hx-on::before-request=\"disableButton(event)\" hx-on::after-request=\"enableButton(event)\" hx-swap=\"none\">Create exit node</button> <button id=\"stop-btn\" class=\"bg-blue-500 hover:bg-blue-700 text-white font-bold py-2 px-4 rounded\" hx-post=\"/stop\" hx-swap=\"none\" hx-indicator=\"#spinner\" hx-on::before-request=\"disableButton(event)\" hx-on::after-request=\"enableButton(event)\">Stop all exit nodes</button></div><div class=\"overflow-x-auto my-4\"><table class=\"table-auto w-full text-sm text-left text-gray-500\"><thead class=\"text-xs text-gray-700 uppercase bg-gray-50\"><tr><th class=\"px-4 py-2\">Hostname</th><th class=\"px-4 py-2\">Address</th><th class=\"px-4 py-2\">Last seen</th></tr></thead> <tbody hx-get=\"/status\" hx-trigger=\"load,every 5s\"></tbody></table></div></div>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "<h2 class=\"text-xl my-4 text-gray-600\">create an exit node in your tailnet in seconds.</h2><div><button id=\"create-btn\" class=\"bg-blue-500 hover:bg-blue-700 text-white font-bold py-2 px-4 mr-2 rounded\" hx-post=\"/create\" hx-indicator=\"#spinner\" hx-target=\"#create-btn\" hx-on::before-request=\"disableButton(event)\" hx-on::after-request=\"enableButton(event)\" hx-swap=\"none\">Create exit node</button> <button id=\"stop-btn\" class=\"bg-blue-500 hover:bg-blue-700 text-white font-bold py-2 px-4 rounded\" hx-post=\"/stop\" hx-swap=\"none\" hx-indicator=\"#spinner\" hx-on::before-request=\"disableButton(event)\" hx-on::after-request=\"enableButton(event)\">Stop all exit nodes</button></div><div class=\"overflow-x-auto my-4\"><table class=\"table-auto w-full text-sm text-left text-gray-500\"><thead class=\"text-xs text-gray-700 uppercase bg-gray-50\"><tr><th class=\"px-4 py-2\">Hostname</th><th class=\"px-4 py-2\">Region</th><th class=\"px-4 py-2\">Address</th><th class=\"px-4 py-2\">Public IP</th><th class=\"px-4 py-2\">Last seen</th><th class=\"px-4 py-2\">Shutdown</th></tr></thead> <tbody hx-get=\"/status\" hx-trigger=\"load,every 5s\"></tbody></table></div></div>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -1,11 +1,25 @@
 package tailout
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lucacome/tailout/internal"
 	"github.com/lucacome/tailout/tailout/config"
+	"github.com/lucacome/tailout/tailout/provider"
+	tsapi "tailscale.com/client/tailscale/v2"
+	"tailscale.com/tsnet"
+
+	// Register the built-in AWS provider.
+	_ "github.com/lucacome/tailout/tailout/provider/aws"
 )
 
 type App struct {
 	Config *config.Config
+
+	// embedded is the tsnet node started in embedded mode.
+	embedded *tsnet.Server
 }
 
 func New() (*App, error) {
@@ -15,3 +29,31 @@ func New() (*App, error) {
 	}
 	return app, nil
 }
+
+// inventory returns the tailout nodes of every region. If some regions cannot
+// be listed, it warns and returns the nodes of the others.
+func (app *App) inventory(ctx context.Context, apiClient *tsapi.Client) ([]internal.Node, error) {
+	p, err := app.cloudProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := internal.GetInventory(ctx, p, apiClient)
+	if errors.Is(err, internal.ErrPartialInventory) {
+		fmt.Println("Warning:", err)
+		return nodes, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tailout nodes: %w", err)
+	}
+	return nodes, nil
+}
+
+// cloudProvider returns the cloud provider selected by the provider configuration key.
+func (app *App) cloudProvider() (provider.Provider, error) {
+	p, err := provider.New(app.Config.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cloud provider: %w", err)
+	}
+	return p, nil
+}
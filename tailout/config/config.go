@@ -5,32 +5,128 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Tailscale      TailscaleConfig `mapstructure:"tailscale"`
-	UI             UIConfig        `mapstructure:"ui"`
-	Region         string          `mapstructure:"region"`
-	Create         CreateConfig    `mapstructure:"create"`
-	NonInteractive bool            `mapstructure:"non_interactive"`
-	DryRun         bool            `mapstructure:"dry_run"`
-	Stop           StopConfig      `mapstructure:"stop"`
+	Tailscale      TailscaleConfig  `mapstructure:"tailscale"`
+	UI             UIConfig         `mapstructure:"ui"`
+	Provider       string           `mapstructure:"provider"`
+	Region         string           `mapstructure:"region"`
+	Create         CreateConfig     `mapstructure:"create"`
+	Connect        ConnectConfig    `mapstructure:"connect"`
+	Egress         EgressConfig     `mapstructure:"egress"`
+	Disconnect     DisconnectConfig `mapstructure:"disconnect"`
+	Embedded       EmbeddedConfig   `mapstructure:"embedded"`
+	Proxy          ProxyConfig      `mapstructure:"proxy"`
+	NonInteractive bool             `mapstructure:"non_interactive"`
+	DryRun         bool             `mapstructure:"dry_run"`
+	Status         StatusConfig     `mapstructure:"status"`
+	Stop           StopConfig       `mapstructure:"stop"`
+	GC             GCConfig         `mapstructure:"gc"`
+	Extend         ExtendConfig     `mapstructure:"extend"`
+	Image          ImageConfig      `mapstructure:"image"`
 }
 
+// Defaults of the create settings, used by every entry point when neither a
+// flag nor the configuration file sets them.
+const (
+	DefaultShutdown     = "2h"
+	DefaultBootstrap    = "ssm"
+	DefaultMarket       = "spot"
+	DefaultInstanceType = "t3a.micro"
+)
+
 type CreateConfig struct {
-	Shutdown string `mapstructure:"shutdown"`
-	Connect  bool   `mapstructure:"connect"`
+	Shutdown         string   `mapstructure:"shutdown"`
+	Connect          bool     `mapstructure:"connect"`
+	Bootstrap        string   `mapstructure:"bootstrap"`
+	InstanceType     string   `mapstructure:"instance_type"`
+	Market           string   `mapstructure:"market"`
+	SpotMaxPrice     string   `mapstructure:"spot_max_price"`
+	VPCID            string   `mapstructure:"vpc_id"`
+	SubnetID         string   `mapstructure:"subnet_id"`
+	SecurityGroupIDs []string `mapstructure:"security_group_ids"`
+	DedicatedNetwork bool     `mapstructure:"dedicated_network"`
+}
+
+type ConnectConfig struct {
+	Region        string        `mapstructure:"region"`
+	Best          bool          `mapstructure:"best"`
+	Watch         bool          `mapstructure:"watch"`
+	WatchInterval time.Duration `mapstructure:"watch_interval"`
+	WatchCreate   bool          `mapstructure:"watch_create"`
+
+	// ExitNodeAllowLANAccess and AcceptDNS are left as they are in the
+	// Tailscale preferences if not set.
+	ExitNodeAllowLANAccess *bool `mapstructure:"exit_node_allow_lan_access"`
+	AcceptDNS              *bool `mapstructure:"accept_dns"`
 }
+
+type ProxyConfig struct {
+	SocksAddress string `mapstructure:"socks_address"`
+	HTTPAddress  string `mapstructure:"http_address"`
+}
+
+type EmbeddedConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Dir     string `mapstructure:"dir"`
+	AuthKey string `mapstructure:"auth_key"`
+}
+
+type DisconnectConfig struct {
+	Clear bool `mapstructure:"clear"`
+}
+
+type EgressConfig struct {
+	EchoURL  string `mapstructure:"echo_url"`
+	NodeEcho bool   `mapstructure:"node_echo"`
+}
+
 type TailscaleConfig struct {
-	BaseURL string `mapstructure:"base_url"`
-	APIKey  string `mapstructure:"api_key"`
+	BaseURL           string `mapstructure:"base_url"`
+	APIKey            string `mapstructure:"api_key"`
+	OAuthClientID     string `mapstructure:"oauth_client_id"`
+	OAuthClientSecret string `mapstructure:"oauth_client_secret"`
+}
+
+type StatusConfig struct {
+	IncludeBuilders bool `mapstructure:"include_builders"`
 }
 
 type StopConfig struct {
-	All bool `mapstructure:"all"`
+	All               bool `mapstructure:"all"`
+	Expired           bool `mapstructure:"expired"`
+	IncludePersistent bool `mapstructure:"include_persistent"`
+	IncludeBuilders   bool `mapstructure:"include_builders"`
+}
+
+type ExtendConfig struct {
+	Shorten bool   `mapstructure:"shorten"`
+	At      string `mapstructure:"at"`
+}
+
+type ImageConfig struct {
+	InstanceType string `mapstructure:"instance_type"`
+	Keep         int    `mapstructure:"keep"`
+}
+
+// SectionAnnotation is the flag annotation naming the configuration section
+// a flag belongs to, for flags shared between commands. Other flags belong to
+// the section of their command.
+const SectionAnnotation = "tailout_config_section"
+
+// DefaultGCGracePeriod is how recent resources have to be for gc to leave
+// them alone. It is longer than the slowest create, which launches the
+// instance twice on a spot fallback and waits for the node to join the
+// tailnet and advertise its routes.
+const DefaultGCGracePeriod = "45m"
+
+type GCConfig struct {
+	GracePeriod string `mapstructure:"grace_period"`
 }
 
 type UIConfig struct {
@@ -76,8 +172,21 @@ func (c *Config) Load(flags *pflag.FlagSet, cmdName string) error {
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	bindEnvironmentVariables(v, *c)
 
-	if err := v.BindPFlags(flags); err != nil {
-		return fmt.Errorf("failed to bind flags: %w", err)
+	// Flags named like a configuration section, such as --connect of the
+	// create command, are not bound at the top level, where their value
+	// would replace the whole section.
+	sections := sectionKeys(*c)
+	var topLevelErr error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if topLevelErr != nil || sections[f.Name] {
+			return
+		}
+		if err := v.BindPFlag(f.Name, f); err != nil {
+			topLevelErr = fmt.Errorf("failed to bind flags: %w", err)
+		}
+	})
+	if topLevelErr != nil {
+		return topLevelErr
 	}
 
 	// Bind tailscale and command specific nested flags and remove prefix when binding
@@ -89,7 +198,11 @@ func (c *Config) Load(flags *pflag.FlagSet, cmdName string) error {
 			return
 		}
 		flagName := strings.ReplaceAll(f.Name, "-", "_")
-		if err := v.BindPFlag(cmdName+"."+f.Name, flags.Lookup(f.Name)); err != nil {
+		section := cmdName
+		if annotation := f.Annotations[SectionAnnotation]; len(annotation) > 0 {
+			section = annotation[0]
+		}
+		if err := v.BindPFlag(section+"."+f.Name, flags.Lookup(f.Name)); err != nil {
 			bindErr = fmt.Errorf("failed to bind flag %s: %w", f.Name, err)
 			return
 		}
@@ -114,6 +227,20 @@ func (c *Config) Load(flags *pflag.FlagSet, cmdName string) error {
 	return nil
 }
 
+// sectionKeys returns the keys of the nested configuration sections.
+func sectionKeys(iface interface{}) map[string]bool {
+	keys := map[string]bool{}
+	ift := reflect.TypeOf(iface)
+	for i := range ift.NumField() {
+		typ := ift.Field(i)
+		tv, ok := typ.Tag.Lookup("mapstructure")
+		if ok && typ.Type.Kind() == reflect.Struct {
+			keys[tv] = true
+		}
+	}
+	return keys
+}
+
 // bindEnvironmentVariables inspects iface's structure and recursively binds its
 // fields to environment variables. This is a workaround to a limitation of
 // Viper, found here:
@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/url"
 	"slices"
+	"time"
 
 	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/huh/spinner"
 	"github.com/lucacome/tailout/internal"
+	tslocal "tailscale.com/client/local"
 	tsapi "tailscale.com/client/tailscale/v2"
 )
 
@@ -16,50 +18,94 @@ func (app *App) Connect(ctx context.Context, args []string) error {
 	var nodeConnect string
 
 	nonInteractive := app.Config.NonInteractive
+	region := app.Config.Connect.Region
 
-	baseURL, err := url.Parse(app.Config.Tailscale.BaseURL)
+	apiClient, err := app.tailscaleClient(ctx, scopeDevicesRead)
 	if err != nil {
-		return fmt.Errorf("failed to parse base URL: %w", err)
+		return err
 	}
 
-	apiClient := &tsapi.Client{
-		APIKey:  app.Config.Tailscale.APIKey,
-		BaseURL: baseURL,
+	localClient, stopEmbedded, err := app.localClient(ctx)
+	if err != nil {
+		return err
 	}
+	defer stopEmbedded()
 
 	var deviceToConnectTo tsapi.Device
-
-	tailoutDevices, err := internal.GetActiveNodes(ctx, apiClient)
-	if err != nil {
-		return fmt.Errorf("failed to get active nodes: %w", err)
-	}
+	// The inventory is listed once, and reused to find the instance of the
+	// node for the egress check.
+	var node internal.Node
+	var nodes []internal.Node
 
 	switch {
 	case len(args) != 0:
-		nodeConnect = args[0]
-		i := slices.IndexFunc(tailoutDevices, func(e tsapi.Device) bool {
-			return e.Hostname == nodeConnect
-		})
-		if i == -1 {
-			return fmt.Errorf("node %s not found", nodeConnect)
+		node, err = app.selectNode(ctx, apiClient, args[0])
+		if err != nil {
+			return err
 		}
-		deviceToConnectTo = tailoutDevices[i]
+		deviceToConnectTo = *node.Device
+		nodeConnect = deviceToConnectTo.NodeID
+	case region != "":
+		var found bool
+		node, found, err = app.regionNode(ctx, apiClient, region)
+		if err != nil {
+			return err
+		}
+		if !found {
+			fmt.Printf("No healthy tailout node found in %s, creating one.\n", region)
+			app.Config.NonInteractive = true
+			app.Config.Region = region
+			app.Config.Create.Connect = true
+			return app.Create(ctx)
+		}
+		deviceToConnectTo = *node.Device
+		nodeConnect = deviceToConnectTo.NodeID
+	case app.Config.Connect.Best:
+		nodes, err = app.inventory(ctx, apiClient)
+		if err != nil {
+			return err
+		}
+		tailoutDevices := internal.OnlineDevices(nodes)
+		if len(tailoutDevices) == 0 {
+			return errors.New("no tailout node found in your tailnet")
+		}
+
+		device, err := bestDevice(ctx, localClient, tailoutDevices)
+		if err != nil {
+			return err
+		}
+		deviceToConnectTo = device
 		nodeConnect = deviceToConnectTo.NodeID
 	case !nonInteractive:
+		nodes, err = app.inventory(ctx, apiClient)
+		if err != nil {
+			return err
+		}
+		tailoutDevices := internal.OnlineDevices(nodes)
 		if len(tailoutDevices) == 0 {
 			return errors.New("no tailout node found in your tailnet")
 		}
 
-		// Create options for huh select
-		options := make([]huh.Option[int], len(tailoutDevices))
-		for i, device := range tailoutDevices {
+		var latencies []internal.DeviceLatency
+		s := spinner.New().Type(spinner.Dots).Title("Measuring latency to tailout nodes...")
+		err := s.Context(ctx).ActionWithErr(func(ctx context.Context) error {
+			latencies = internal.MeasureLatencies(ctx, localClient, tailoutDevices)
+			return nil
+		}).Run()
+		if err != nil {
+			return fmt.Errorf("failed to measure latency: %w", err)
+		}
+
+		// Create options for huh select, fastest node first
+		options := make([]huh.Option[int], len(latencies))
+		for i, latency := range latencies {
 			// Get IP address if available
 			addr := "no IP"
-			if len(device.Addresses) > 0 {
-				addr = device.Addresses[0]
+			if len(latency.Device.Addresses) > 0 {
+				addr = latency.Device.Addresses[0]
 			}
-			// Display hostname and IP address
-			label := fmt.Sprintf("%s (%s)", device.Hostname, addr)
+			// Display hostname, IP address and latency
+			label := fmt.Sprintf("%s (%s, %s)", latency.Device.Hostname, addr, latency)
 			options[i] = huh.NewOption(label, i)
 		}
 
@@ -73,18 +119,18 @@ func (app *App) Connect(ctx context.Context, args []string) error {
 			),
 		)
 
-		err := form.RunWithContext(ctx)
+		err = form.RunWithContext(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to select node: %w", err)
 		}
 
-		deviceToConnectTo = tailoutDevices[selectedIndex]
+		deviceToConnectTo = latencies[selectedIndex].Device
 		nodeConnect = deviceToConnectTo.NodeID
 	default:
 		return errors.New("no node name provided")
 	}
 
-	errUpdate := internal.UpdateExitNode(ctx, apiClient, nodeConnect)
+	errUpdate := internal.UpdateExitNode(ctx, localClient, apiClient, nodeConnect, app.exitNodeOptions())
 	if errUpdate != nil {
 		return fmt.Errorf("failed to connect to exit node: %w", errUpdate)
 	}
@@ -96,5 +142,103 @@ func (app *App) Connect(ctx context.Context, args []string) error {
 	}
 	fmt.Printf("Connected to node %s (%s) via Tailscale.\n", deviceToConnectTo.Hostname, addr)
 
+	var errNode error
+	if node.Device == nil {
+		node, errNode = app.deviceNode(nodes, deviceToConnectTo)
+	}
+	if errNode != nil {
+		fmt.Println("Egress check: unknown,", errNode)
+	} else if errVerify := app.verifyEgress(ctx, node); errVerify != nil {
+		fmt.Println("Egress check: unknown,", errVerify)
+	}
+
+	if app.Config.Connect.Watch {
+		return app.watchExitNode(ctx, localClient, apiClient)
+	}
+	if app.embedded != nil {
+		fmt.Println("The embedded node uses the exit node until tailout exits, press Ctrl-C to stop.")
+		<-ctx.Done()
+	}
 	return nil
 }
+
+// bestDevice returns the exit node suggested by Tailscale if it is one of the
+// tailout devices, or else the device with the lowest latency.
+func bestDevice(ctx context.Context, localClient *tslocal.Client, devices []tsapi.Device) (tsapi.Device, error) {
+	if device, ok := internal.SuggestedDevice(ctx, localClient, devices); ok {
+		fmt.Printf("Tailscale suggests %s as exit node.\n", device.Hostname)
+		return device, nil
+	}
+
+	latencies := internal.MeasureLatencies(ctx, localClient, devices)
+	best := latencies[0]
+	if best.Err != nil {
+		return tsapi.Device{}, fmt.Errorf("no tailout node is reachable: %w", best.Err)
+	}
+	fmt.Printf("Selected %s with the lowest latency (%s).\n", best.Device.Hostname, best)
+	return best.Device, nil
+}
+
+// selectNode returns the node designated by the selector, which must have
+// joined the tailnet.
+func (app *App) selectNode(ctx context.Context, apiClient *tsapi.Client, selector string) (internal.Node, error) {
+	nodes, err := app.inventory(ctx, apiClient)
+	if err != nil {
+		return internal.Node{}, err
+	}
+
+	node, err := internal.SelectNode(nodes, selector)
+	if err != nil {
+		return internal.Node{}, fmt.Errorf("failed to select node: %w", err)
+	}
+	if node.Device == nil {
+		return internal.Node{}, fmt.Errorf("node %s has not joined the tailnet", node.Hostname())
+	}
+	return node, nil
+}
+
+// exitNodeOptions returns the client preferences applied with the exit node.
+func (app *App) exitNodeOptions() internal.ExitNodeOptions {
+	return internal.ExitNodeOptions{
+		AllowLANAccess: app.Config.Connect.ExitNodeAllowLANAccess,
+		AcceptDNS:      app.Config.Connect.AcceptDNS,
+		SkipState:      app.embedded != nil,
+	}
+}
+
+// deviceNode returns the tailout node of the device from the inventory.
+// Its instance is only needed if the egress check compares against its
+// public IP address.
+func (app *App) deviceNode(nodes []internal.Node, device tsapi.Device) (internal.Node, error) {
+	if app.Config.Egress.NodeEcho {
+		return internal.Node{Device: &device}, nil
+	}
+
+	i := slices.IndexFunc(nodes, func(n internal.Node) bool {
+		return n.Device != nil && n.Device.NodeID == device.NodeID
+	})
+	if i == -1 {
+		return internal.Node{}, fmt.Errorf("no instance found for node %s", device.Hostname)
+	}
+	return nodes[i], nil
+}
+
+// regionNode returns a healthy tailout node of the region: its instance is
+// running and not past its deadline, and its device is online.
+func (app *App) regionNode(ctx context.Context, apiClient *tsapi.Client, region string) (internal.Node, bool, error) {
+	nodes, err := app.inventory(ctx, apiClient)
+	if err != nil {
+		return internal.Node{}, false, err
+	}
+
+	now := time.Now()
+	for _, node := range nodes {
+		if node.Region() != region || node.Device == nil || node.Instance.State != "running" {
+			continue
+		}
+		if node.Device.ConnectedToControl && !node.Expired(now) {
+			return node, true, nil
+		}
+	}
+	return internal.Node{}, false, nil
+}
@@ -1,29 +1,41 @@
 package tailout
 
 import (
+	"cmp"
 	"context"
-	"encoding/base64"
+	"crypto/rand"
 	"errors"
 	"fmt"
-	"net/url"
-	"sort"
+	"maps"
+	"os"
+	"os/user"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
-	"github.com/aws/aws-sdk-go-v2/service/ssm"
-	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
-	"github.com/aws/smithy-go"
-
 	"github.com/charmbracelet/huh/spinner"
 	"github.com/lucacome/tailout/internal"
+	"github.com/lucacome/tailout/tailout/config"
+	"github.com/lucacome/tailout/tailout/provider"
 	tsapi "tailscale.com/client/tailscale/v2"
 )
 
+// authKeyExpiry is the lifetime of the auth key a node joins the tailnet
+// with, long enough to launch the instance and install Tailscale on it.
+const authKeyExpiry = 30 * time.Minute
+
+// joinTimeout is how long a node has to join the tailnet once Tailscale is
+// being installed, which takes a few minutes from the user data.
+const joinTimeout = 10 * time.Minute
+
+// routesTimeout is how long a node that joined the tailnet has to advertise
+// the exit node routes.
+const routesTimeout = time.Minute
+
+// shutdownNone is the shutdown value of nodes that never shut themselves down.
+const shutdownNone = "none"
+
 var (
 	ErrUserAborted = errors.New("user aborted instance creation")
 	ErrDryRun      = errors.New("dry run successful")
@@ -34,63 +46,58 @@ func (app *App) Create(ctx context.Context) error {
 	region := app.Config.Region
 	dryRun := app.Config.DryRun
 	connect := app.Config.Create.Connect
-	shutdown := app.Config.Create.Shutdown
+	shutdown := cmp.Or(app.Config.Create.Shutdown, config.DefaultShutdown)
+	bootstrap := cmp.Or(app.Config.Create.Bootstrap, config.DefaultBootstrap)
+	instanceType := cmp.Or(app.Config.Create.InstanceType, config.DefaultInstanceType)
+	market := cmp.Or(app.Config.Create.Market, config.DefaultMarket)
+	spotMaxPrice := app.Config.Create.SpotMaxPrice
 
-	baseURL, err := url.Parse(app.Config.Tailscale.BaseURL)
+	network, err := app.createNetwork()
 	if err != nil {
-		return fmt.Errorf("failed to parse base URL: %w", err)
+		return err
 	}
 
-	apiClient := &tsapi.Client{
-		APIKey:  app.Config.Tailscale.APIKey,
-		BaseURL: baseURL,
+	apiClient, err := app.tailscaleClient(ctx, scopeAuthKeys, scopeDevicesRead, scopeRoutes)
+	if err != nil {
+		return err
 	}
 
-	keyCapabilities := tsapi.KeyCapabilities{
-		Devices: struct {
-			Create struct { //nolint:govet
-				Reusable      bool     `json:"reusable"`
-				Ephemeral     bool     `json:"ephemeral"`
-				Tags          []string `json:"tags"`
-				Preauthorized bool     `json:"preauthorized"`
-			} `json:"create"`
-		}{
-			Create: struct { //nolint:govet
-				Reusable      bool     `json:"reusable"`
-				Ephemeral     bool     `json:"ephemeral"`
-				Tags          []string `json:"tags"`
-				Preauthorized bool     `json:"preauthorized"`
-			}{
-				Reusable:      false,
-				Ephemeral:     true,
-				Tags:          []string{"tag:tailout"},
-				Preauthorized: true,
-			},
-		},
+	switch market {
+	case provider.MarketSpot, provider.MarketOnDemand, provider.MarketSpotThenOnDemand:
+	default:
+		return fmt.Errorf("invalid market %q, must be one of %s, %s or %s", market, provider.MarketSpot, provider.MarketOnDemand, provider.MarketSpotThenOnDemand)
 	}
 
-	key, err := apiClient.Keys().Create(ctx, tsapi.CreateKeyRequest{
-		Description:  "tailout",
-		Capabilities: keyCapabilities,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create auth key: %w", err)
+	switch bootstrap {
+	case provider.BootstrapSSM, provider.BootstrapUserData:
+	default:
+		return fmt.Errorf("invalid bootstrap strategy %q, must be %s or %s", bootstrap, provider.BootstrapSSM, provider.BootstrapUserData)
 	}
 
-	// TODO: add option for no shutdown
-	duration, err := time.ParseDuration(shutdown)
-	if err != nil {
-		return fmt.Errorf("failed to parse duration: %w", err)
+	// Persistent nodes have no shutdown job and run until they are stopped.
+	persistent := shutdown == shutdownNone
+	var duration time.Duration
+	shutdownAfter := ""
+	if !persistent {
+		duration, err = time.ParseDuration(shutdown)
+		if err != nil {
+			return fmt.Errorf("failed to parse duration: %w", err)
+		}
+
+		durationMinutes := int(duration.Minutes())
+		if durationMinutes < 1 {
+			return errors.New("duration must be at least 1 minute")
+		}
+		shutdownAfter = strconv.Itoa(durationMinutes)
 	}
 
-	durationMinutes := int(duration.Minutes())
-	if durationMinutes < 1 {
-		return errors.New("duration must be at least 1 minute")
+	p, err := app.cloudProvider()
+	if err != nil {
+		return err
 	}
 
-	// Create EC2 service client
 	if region == "" && !nonInteractive {
-		region, err = internal.SelectRegion(ctx)
+		region, err = internal.SelectRegion(ctx, p)
 		if err != nil {
 			return fmt.Errorf("failed to select region: %w", err)
 		}
@@ -98,12 +105,7 @@ func (app *App) Create(ctx context.Context) error {
 		return errors.New("selected non-interactive mode but no region was explicitly specified")
 	}
 
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region), config.WithRetryMaxAttempts(5), config.WithRetryMode(aws.RetryModeStandard))
-	if err != nil {
-		return fmt.Errorf("unable to load SDK config: %w", err)
-	}
-
-	runInput, errPrep := prepareInstance(ctx, cfg, aws.Bool(dryRun), strconv.Itoa(durationMinutes))
+	launchReq, errPrep := prepareInstance(ctx, p, region, instanceType, market, spotMaxPrice, network, bootstrap, dryRun, nonInteractive, shutdownAfter)
 	if errPrep != nil {
 		if errors.Is(errPrep, ErrUserAborted) {
 			fmt.Println("instance creation aborted.")
@@ -111,29 +113,70 @@ func (app *App) Create(ctx context.Context) error {
 		}
 		return fmt.Errorf("failed to prepare instance: %w", errPrep)
 	}
-	if runInput == nil {
+	if launchReq == nil {
 		fmt.Println("no user input provided, aborting instance creation")
 		return nil
 	}
 
+	if persistent && !nonInteractive {
+		result, promptErr := internal.PromptYesNo(ctx, "This node will not shut itself down and is billed until it is stopped. Are you sure?")
+		if promptErr != nil {
+			return fmt.Errorf("failed to prompt for confirmation: %w", promptErr)
+		}
+		if !result {
+			fmt.Println("instance creation aborted.")
+			return nil
+		}
+	}
+
+	// The deadline and creation parameters are recorded on the instance so
+	// that they can be shown and changed after creation.
+	launchReq.Tags = map[string]string{
+		provider.TagCreatedBy: creator(),
+		provider.TagShutdown:  shutdown,
+		provider.TagNetwork:   networkTag(network),
+	}
+	if persistent {
+		launchReq.Tags[provider.TagPersistent] = "true"
+	} else {
+		launchReq.Tags[provider.TagExpiresAt] = time.Now().Add(duration).UTC().Format(time.RFC3339)
+	}
+
+	// The key is handed to the instance through its user data, so it is
+	// needed before the instance exists. The hostname is chosen beforehand
+	// to name the key after the node, and the key ID is recorded on the
+	// instance so that stop can revoke it.
+	launchReq.Hostname = nodeHostname(region)
+	var key *tsapi.Key
+	if !dryRun {
+		key, err = createAuthKey(ctx, apiClient, launchReq.Hostname, "tag:tailout")
+		if err != nil {
+			return err
+		}
+		launchReq.AuthKey = key.Key
+		launchReq.Tags[provider.TagAuthKey] = key.ID
+	}
+
 	var publicIPAddress string
 	var nodeName string
 	var instanceID string
+	var instanceMarket string
 	s := spinner.New().Type(spinner.Dots).Title("Creating instance...")
 	errSpin := s.Context(ctx).ActionWithErr(func(context.Context) error {
-		instance, createErr := createInstance(ctx, cfg, runInput, s)
+		instance, createErr := createInstance(ctx, p, launchReq, s)
 		if createErr != nil {
 			return createErr
 		}
 		if dryRun {
 			return ErrDryRun
 		}
-		if instance.InstanceID == "" {
+		if instance.ID == "" {
 			return errors.New("no instance ID returned")
 		}
-		instanceID = instance.InstanceID
+		instanceID = instance.ID
 		nodeName = instance.Name
-		publicIPAddress = instance.IP
+		publicIPAddress = instance.PublicIP
+		instanceMarket = instance.Market
 		return nil
 	}).Run()
 	if errSpin != nil {
@@ -141,40 +184,28 @@ func (app *App) Create(ctx context.Context) error {
 			fmt.Println("Dry run successful. Instance can be created.")
 			return nil
 		}
-		return fmt.Errorf("failed to create instance: %w", errSpin)
-	}
-
-	st := spinner.New().Type(spinner.Dots).Title("Installing Tailscale...")
-	errSpint := st.Context(ctx).ActionWithErr(func(context.Context) error {
-		errInstall := installTailScale(ctx, cfg, key.Key, nodeName, instanceID, st)
-		if errInstall != nil {
-			return errInstall
+		if key != nil {
+			revokeAuthKey(ctx, apiClient, key.ID)
 		}
-		return nil
-	}).Run()
-	if errSpint != nil {
-		return fmt.Errorf("failed to install Tailscale: %w", errSpint)
+		return fmt.Errorf("failed to create instance: %w", errSpin)
 	}
+	fmt.Println("Auth key delivered through the user data of the instance, only readable with IMDSv2 until the key is revoked")
 
-	fmt.Println("Tailscale installed.")
-
-	nodes, deviceErr := apiClient.Devices().List(ctx)
-	if deviceErr != nil {
-		return fmt.Errorf("failed to get devices: %w", deviceErr)
+	// The key is only needed to join the tailnet, so it is revoked as soon as
+	// the node joined or failed to.
+	joinErr := joinTailnet(ctx, p, apiClient, bootstrap, region, nodeName, instanceID)
+	revokeAuthKey(ctx, apiClient, key.ID)
+	if joinErr != nil {
+		return joinErr
 	}
 
-	found := false
-	for _, node := range nodes {
-		if node.Hostname == nodeName {
-			fmt.Printf("Node %s joined tailnet.\n", nodeName)
-			fmt.Println("Public IP address:", publicIPAddress)
-			fmt.Println("Planned termination time:", time.Now().Add(duration).Format(time.RFC3339))
-			found = true
-			break
-		}
-	}
-	if !found {
-		return errors.New("failed to find the created node in tailnet")
+	fmt.Printf("Node %s joined tailnet.\n", nodeName)
+	fmt.Println("Public IP address:", publicIPAddress)
+	fmt.Println("Market:", instanceMarket)
+	if persistent {
+		fmt.Println("Planned termination time: none, the node runs until it is stopped")
+	} else {
+		fmt.Println("Planned termination time:", launchReq.Tags[provider.TagExpiresAt])
 	}
 
 	if connect {
@@ -188,113 +219,58 @@ func (app *App) Create(ctx context.Context) error {
 	return nil
 }
 
-type instance struct {
-	InstanceID string
-	Name       string
-	IP         string
-}
-
-func prepareInstance(ctx context.Context, cfg aws.Config, dryRun *bool, shutdownDuration string) (instance *ec2.RunInstancesInput, err error) {
-	ec2Svc := ec2.NewFromConfig(cfg)
+func prepareInstance(ctx context.Context, p provider.Provider, region string, instanceType string, market string, spotMaxPrice string, network provider.Network, bootstrap string, dryRun bool, nonInteractive bool, shutdownDuration string) (*provider.LaunchRequest, error) {
+	if instanceType == "" {
+		return nil, errors.New("no instance type specified")
+	}
 
-	// DescribeImages to get the latest Amazon Linux AMI
-	amazonLinuxImages, err := ec2Svc.DescribeImages(ctx, &ec2.DescribeImagesInput{
-		Filters: []types.Filter{
-			{
-				Name:   aws.String("name"),
-				Values: []string{"al2023-ami-*"},
-			},
-			{
-				Name:   aws.String("state"),
-				Values: []string{"available"},
-			},
-			{
-				Name:   aws.String("is-public"),
-				Values: []string{"true"},
-			},
-			{
-				Name:   aws.String("architecture"),
-				Values: []string{"x86_64"},
-			},
-		},
-		Owners: []string{"amazon"},
-	})
+	image, err := p.LookupImage(ctx, region, instanceType)
 	if err != nil {
-		return nil, fmt.Errorf("failed to describe Amazon Linux images: %w", err)
+		return nil, fmt.Errorf("failed to look up image: %w", err)
 	}
 
-	if len(amazonLinuxImages.Images) == 0 {
-		return nil, errors.New("no Amazon Linux images found")
+	launchReq := &provider.LaunchRequest{
+		Region:        region,
+		Image:         image,
+		InstanceType:  instanceType,
+		Market:        market,
+		SpotMaxPrice:  spotMaxPrice,
+		Network:       network,
+		ShutdownAfter: shutdownDuration,
+		Bootstrap:     bootstrap,
+		DryRun:        dryRun,
 	}
 
-	sort.Slice(amazonLinuxImages.Images, func(i, j int) bool {
-		return *amazonLinuxImages.Images[i].CreationDate > *amazonLinuxImages.Images[j].CreationDate
-	})
-
-	// Get the latest Amazon Linux AMI ID
-	latestAMI := amazonLinuxImages.Images[0]
-	imageID := *latestAMI.ImageId
-	imageName := *latestAMI.Name
-	imageOwner := *latestAMI.ImageOwnerAlias
-	imageArchitecture := latestAMI.Architecture
-
-	// Define the instance details
-	// TODO: add option for instance type
-	// instanceType := "t3a.micro"
-	// TODO: Fix shutdown time
-	userDataScript := `#!/bin/bash
-# Allow ip forwarding
-echo 'net.ipv4.ip_forward = 1' | sudo tee -a /etc/sysctl.conf
-echo 'net.ipv6.conf.all.forwarding = 1' | sudo tee -a /etc/sysctl.conf
-sudo sysctl -p /etc/sysctl.conf
-sudo echo "sudo shutdown" | at now + ` + shutdownDuration + ` minutes`
-
-	// Encode the string in base64
-	userDataScriptBase64 := base64.StdEncoding.EncodeToString([]byte(userDataScript))
-
-	// Create instance input parameters
-	runInput := &ec2.RunInstancesInput{
-		ImageId:      aws.String(imageID),
-		InstanceType: types.InstanceTypeT3aMicro,
-		MinCount:     aws.Int32(1),
-		MaxCount:     aws.Int32(1),
-		UserData:     aws.String(userDataScriptBase64),
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeInstance,
-				Tags: []types.Tag{
-					{
-						Key:   aws.String("App"),
-						Value: aws.String("tailout"),
-					},
-				},
-			},
-		},
-		DryRun: dryRun,
-		InstanceMarketOptions: &types.InstanceMarketOptionsRequest{
-			MarketType: types.MarketTypeSpot,
-			SpotOptions: &types.SpotMarketOptions{
-				InstanceInterruptionBehavior: types.InstanceInterruptionBehaviorTerminate,
-			},
-		},
+	account, err := p.Account(ctx, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
 	}
 
-	stsSvc := sts.NewFromConfig(cfg)
+	shutdownDescription := shutdownDuration + " minutes"
+	if shutdownDuration == "" {
+		shutdownDescription = "never, the node runs until it is stopped"
+	}
 
-	identity, err := stsSvc.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get account ID: %w", err)
+	marketDescription := market
+	if spotMaxPrice != "" && market != provider.MarketOnDemand {
+		marketDescription += " (spot max price: " + spotMaxPrice + ")"
 	}
 
-	fmt.Printf(`Creating tailout node in AWS with the following parameters:
-- AWS Account ID: %s
-- AMI ID: %s (%s by %s)
-- AMI Architecture: %s
+	fmt.Printf(`Creating tailout node in %s with the following parameters:
+- Account ID: %s
+- Image ID: %s (%s by %s)
+- Image Architecture: %s
 - Instance Type: %s
+- Market: %s
 - Region: %s
 - Auto shutdown after: %s
-- Network: default VPC / Subnet / Security group of the region
-	`, *identity.Account, imageID, imageName, imageOwner, imageArchitecture, types.InstanceTypeT3aMicro, cfg.Region, shutdownDuration)
+- Network: %s
+- Bootstrap: %s
+	`, strings.ToUpper(p.Name()), account, image.ID, image.Name, image.Owner, image.Architecture, launchReq.InstanceType, marketDescription, region, shutdownDescription, networkDescription(network), bootstrap)
+
+	if nonInteractive {
+		return launchReq, nil
+	}
 
 	result, promptErr := internal.PromptYesNo(ctx, "Do you want to create this instance?")
 	if promptErr != nil {
@@ -304,130 +280,295 @@ sudo echo "sudo shutdown" | at now + ` + shutdownDuration + ` minutes`
 	if !result {
 		return nil, ErrUserAborted
 	}
-	return runInput, nil
+	return launchReq, nil
+}
+
+// creator returns the user and machine tailout runs as, to identify who created a node.
+func creator() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		name += "@" + hostname
+	}
+	return name
 }
 
-func createInstance(ctx context.Context, cfg aws.Config, runInput *ec2.RunInstancesInput, spin *spinner.Spinner) (instance instance, err error) {
-	ec2Svc := ec2.NewFromConfig(cfg)
+// createNetwork returns the network instances are launched in, as configured
+// by the create settings.
+func (app *App) createNetwork() (provider.Network, error) {
+	network := provider.Network{
+		VPCID:            app.Config.Create.VPCID,
+		SubnetID:         app.Config.Create.SubnetID,
+		SecurityGroupIDs: app.Config.Create.SecurityGroupIDs,
+		Dedicated:        app.Config.Create.DedicatedNetwork,
+	}
+	if network.Dedicated && (network.VPCID != "" || network.SubnetID != "" || len(network.SecurityGroupIDs) > 0) {
+		return provider.Network{}, errors.New("the dedicated network cannot be combined with a VPC, subnet or security groups")
+	}
+	return network, nil
+}
 
-	// Run the EC2 instance
-	runResult, runErr := ec2Svc.RunInstances(ctx, runInput)
-	if runErr != nil {
-		var dryRunErr *smithy.GenericAPIError
-		if errors.As(runErr, &dryRunErr) && dryRunErr.Code == "DryRunOperation" {
-			return instance, nil
-		}
-		return instance, fmt.Errorf("failed to create EC2 instance: %w", runErr)
+// networkTag returns a compact description of the network, short enough to be recorded as a tag.
+func networkTag(network provider.Network) string {
+	switch {
+	case network.Dedicated:
+		return "dedicated"
+	case network.SubnetID != "":
+		return network.SubnetID
+	case network.VPCID != "":
+		return network.VPCID
+	default:
+		return "default"
 	}
+}
 
-	if len(runResult.Instances) == 0 {
-		fmt.Println("No instances created.")
-		return instance, nil
+// networkDescription returns a human readable description of where the instance is launched.
+func networkDescription(network provider.Network) string {
+	if network.Dedicated {
+		return "dedicated tailout VPC, created if it does not exist"
 	}
-	createdInstance := runResult.Instances[0]
 
-	fmt.Println("Instance created:", *createdInstance.InstanceId)
+	parts := []string{}
+	switch {
+	case network.SubnetID != "":
+		parts = append(parts, "subnet "+network.SubnetID)
+	case network.VPCID != "":
+		parts = append(parts, "VPC "+network.VPCID)
+	default:
+		parts = append(parts, "default VPC / Subnet")
+	}
 
-	nodeName := fmt.Sprintf("tailout-%s-%s", cfg.Region, *createdInstance.InstanceId)
-	// Create tags for the instance
-	tags := []types.Tag{
-		{
-			Key:   aws.String("Name"),
-			Value: aws.String(nodeName),
-		},
+	if len(network.SecurityGroupIDs) > 0 {
+		parts = append(parts, "security groups "+strings.Join(network.SecurityGroupIDs, ", "))
+	} else {
+		parts = append(parts, "default security group")
 	}
 
-	// Add the tags to the instance
-	_, err = ec2Svc.CreateTags(ctx, &ec2.CreateTagsInput{
-		Resources: []string{*createdInstance.InstanceId},
-		Tags:      tags,
-	})
-	if err != nil {
-		return instance, fmt.Errorf("failed to add tags to the instance: %w", err)
+	return strings.Join(parts, " / ") + " of the region"
+}
+
+func createInstance(ctx context.Context, p provider.Provider, launchReq *provider.LaunchRequest, spin *spinner.Spinner) (provider.Instance, error) {
+	launchReq.Progress = func(title string) { spin.Title(title) }
+
+	// The market is recorded as the one the instance is launched with, which
+	// differs from the requested one after a fallback to on-demand.
+	req := *launchReq
+	req.Tags = maps.Clone(launchReq.Tags)
+	launch := func(market string) (provider.Instance, error) {
+		req.Market = market
+		req.Tags[provider.TagMarket] = market
+		return p.Launch(ctx, req)
+	}
+
+	market := req.Market
+	if market == provider.MarketSpotThenOnDemand {
+		market = provider.MarketSpot
 	}
 
-	spin.Title("Waiting for instance to be running...")
-	err = ec2.NewInstanceStatusOkWaiter(ec2Svc).Wait(ctx, &ec2.DescribeInstanceStatusInput{
-		InstanceIds: []string{*createdInstance.InstanceId},
-	}, time.Minute*5)
+	instance, err := launch(market)
+	if err != nil && launchReq.Market == provider.MarketSpotThenOnDemand && errors.Is(err, provider.ErrSpotUnavailable) {
+		fmt.Println("Spot capacity unavailable, retrying as on-demand:", err)
+		spin.Title("Creating on-demand instance...")
+		instance, err = launch(provider.MarketOnDemand)
+	}
 	if err != nil {
-		return instance, fmt.Errorf("failed to wait for instance to be created: %w", err)
+		return instance, fmt.Errorf("failed to launch %s instance: %w", req.Market, err)
 	}
+	return instance, nil
+}
+
+// nodeHostname returns a new tailnet hostname for a node of the region.
+func nodeHostname(region string) string {
+	return "tailout-" + region + "-" + strings.ToLower(rand.Text()[:8])
+}
 
-	describeInput := &ec2.DescribeInstancesInput{
-		InstanceIds: []string{*createdInstance.InstanceId},
+// createAuthKey creates the single use auth key a node joins the tailnet
+// with, tagged with tag. The description is unique to the node, so that the
+// key can be traced back to it.
+func createAuthKey(ctx context.Context, apiClient *tsapi.Client, description string, tag string) (*tsapi.Key, error) {
+	keyCapabilities := tsapi.KeyCapabilities{
+		Devices: struct {
+			Create struct { //nolint:govet
+				Reusable      bool     `json:"reusable"`
+				Ephemeral     bool     `json:"ephemeral"`
+				Tags          []string `json:"tags"`
+				Preauthorized bool     `json:"preauthorized"`
+			} `json:"create"`
+		}{
+			Create: struct { //nolint:govet
+				Reusable      bool     `json:"reusable"`
+				Ephemeral     bool     `json:"ephemeral"`
+				Tags          []string `json:"tags"`
+				Preauthorized bool     `json:"preauthorized"`
+			}{
+				Reusable:      false,
+				Ephemeral:     true,
+				Tags:          []string{tag},
+				Preauthorized: true,
+			},
+		},
 	}
 
-	describeResult, err := ec2Svc.DescribeInstances(ctx, describeInput)
+	key, err := apiClient.Keys().Create(ctx, tsapi.CreateKeyRequest{
+		Description:   description,
+		ExpirySeconds: int64(authKeyExpiry.Seconds()),
+		Capabilities:  keyCapabilities,
+	})
 	if err != nil {
-		return instance, fmt.Errorf("failed to describe EC2 instance: %w", err)
+		return nil, fmt.Errorf("failed to create auth key: %w", err)
 	}
+	return key, nil
+}
 
-	if len(describeResult.Reservations) == 0 {
-		return instance, errors.New("no reservations found")
+// revokeAuthKey deletes the auth key, even if ctx was canceled, so that no
+// valid key is left in the tailnet.
+func revokeAuthKey(ctx context.Context, apiClient *tsapi.Client, keyID string) {
+	err := apiClient.Keys().Delete(context.WithoutCancel(ctx), keyID)
+	if err != nil && !tsapi.IsNotFound(err) {
+		fmt.Printf("Failed to revoke auth key %s, it expires in %s: %v\n", keyID, authKeyExpiry, err)
 	}
+}
 
-	reservation := describeResult.Reservations[0]
-	if len(reservation.Instances) == 0 {
-		return instance, errors.New("no instances found")
-	}
+// joinTailnet installs Tailscale on the instance with the SSM strategy, and
+// waits for the node to join the tailnet. With the user data strategy, the
+// instance installs Tailscale on its own.
+func joinTailnet(ctx context.Context, p provider.Provider, apiClient *tsapi.Client, bootstrap string, region string, nodeName string, instanceID string) error {
+	if bootstrap == provider.BootstrapSSM {
+		st := spinner.New().Type(spinner.Dots).Title("Installing Tailscale...")
+		errSpint := st.Context(ctx).ActionWithErr(func(context.Context) error {
+			errInstall := installTailScale(ctx, p, region, nodeName, instanceID, st)
+			if errInstall != nil {
+				return errInstall
+			}
+			return nil
+		}).Run()
+		if errSpint != nil {
+			return fmt.Errorf("failed to install Tailscale: %w", errSpint)
+		}
 
-	instance1 := reservation.Instances[0]
-	if instance1.PublicIpAddress == nil {
-		return instance, errors.New("no public IP address found")
+		fmt.Println("Tailscale installed.")
 	}
 
-	instance.Name = nodeName
-	instance.InstanceID = *createdInstance.InstanceId
-	instance.IP = *instance1.PublicIpAddress
+	var device tsapi.Device
+	sw := spinner.New().Type(spinner.Dots).Title("Waiting for the node to join the tailnet...")
+	errSpinw := sw.Context(ctx).ActionWithErr(func(context.Context) error {
+		var waitErr error
+		device, waitErr = waitForDevice(ctx, apiClient, nodeName, instanceID, joinTimeout)
+		return waitErr
+	}).Run()
+	if errSpinw != nil {
+		return fmt.Errorf("failed to find the created node in tailnet: %w", errSpinw)
+	}
 
-	return instance, nil
+	sr := spinner.New().Type(spinner.Dots).Title("Checking the exit node routes...")
+	errSpinr := sr.Context(ctx).ActionWithErr(func(context.Context) error {
+		return approveExitNodeRoutes(ctx, apiClient, device, routesTimeout)
+	}).Run()
+	if errSpinr != nil {
+		return fmt.Errorf("failed to check the exit node routes: %w", errSpinr)
+	}
+	return nil
 }
 
-func installTailScale(ctx context.Context, cfg aws.Config, key string, nodeName string, instanceID string, spin *spinner.Spinner) error {
-	ssmSvc := ssm.NewFromConfig(cfg)
-
-	input := &ssm.SendCommandInput{
-		InstanceIds:  []string{instanceID},
-		DocumentName: aws.String("AWS-RunShellScript"),
-		Parameters: map[string][]string{
-			"commands": {
-				"echo 'Installing Tailscale...'",
-				"curl -fsSL https://tailscale.com/install.sh | sh",
-				"echo 'Starting Tailscale...'",
-				"sudo tailscale up --auth-key=" + key + " --hostname=" + nodeName + " --advertise-exit-node --ssh",
-				"echo 'Tailscale installation and configuration completed.'",
-			},
-		},
+// waitForDevice polls the devices of the tailnet until the node is online, or
+// until the timeout expires. The device is matched by its hostname, or by the
+// instance ID it contains in case the hostname was changed or de-duplicated.
+func waitForDevice(ctx context.Context, apiClient *tsapi.Client, hostname string, instanceID string, timeout time.Duration) (tsapi.Device, error) {
+	deadline := time.Now().Add(timeout)
+	registered := false
+	for {
+		devices, err := apiClient.Devices().List(ctx)
+		if err != nil {
+			return tsapi.Device{}, fmt.Errorf("failed to get devices: %w", err)
+		}
+		for _, device := range devices {
+			if !internal.DeviceMatchesInstance(device, provider.Instance{ID: instanceID, Name: hostname}) {
+				continue
+			}
+			if device.ConnectedToControl {
+				return device, nil
+			}
+			registered = true
+		}
+
+		if time.Now().After(deadline) {
+			if registered {
+				return tsapi.Device{}, fmt.Errorf("node %s joined the tailnet but is not online after %s", hostname, timeout)
+			}
+			return tsapi.Device{}, fmt.Errorf("node %s did not join the tailnet within %s", hostname, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return tsapi.Device{}, fmt.Errorf("operation canceled: %w", ctx.Err())
+		case <-time.After(5 * time.Second):
+		}
 	}
+}
 
-	spin.Title("Installing Tailscale...")
-	output, err := ssmSvc.SendCommand(ctx, input)
-	if err != nil {
-		return fmt.Errorf("failed to send SSM command: %w", err)
+// exitNodeRoutes are the routes a node advertises to be used as an exit node.
+var exitNodeRoutes = []string{"0.0.0.0/0", "::/0"}
+
+// approveExitNodeRoutes waits for the device to advertise the exit node
+// routes and approves them if the autoApprovers of the policy did not.
+func approveExitNodeRoutes(ctx context.Context, apiClient *tsapi.Client, device tsapi.Device, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		routes, err := apiClient.Devices().SubnetRoutes(ctx, device.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get the routes of %s: %w", device.Hostname, err)
+		}
+
+		if containsAll(routes.Enabled, exitNodeRoutes) {
+			return nil
+		}
+
+		if containsAll(routes.Advertised, exitNodeRoutes) {
+			enabled := slices.Clone(routes.Enabled)
+			for _, route := range exitNodeRoutes {
+				if !slices.Contains(enabled, route) {
+					enabled = append(enabled, route)
+				}
+			}
+			err = apiClient.Devices().SetSubnetRoutes(ctx, device.ID, enabled)
+			if err != nil {
+				return fmt.Errorf("the exit node routes of %s were not auto approved, run tailout init to auto approve tag:tailout exit nodes or approve them in the admin console: %w", device.Hostname, err)
+			}
+			fmt.Println("Approved the exit node routes of", device.Hostname)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("node %s does not advertise the exit node routes", device.Hostname)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("operation canceled: %w", ctx.Err())
+		case <-time.After(5 * time.Second):
+		}
 	}
+}
 
-	commandID := *output.Command.CommandId
-	waiter := ssm.NewCommandExecutedWaiter(ssmSvc)
-	waitErr := waiter.Wait(ctx, &ssm.GetCommandInvocationInput{
-		CommandId:  aws.String(commandID),
-		InstanceId: aws.String(instanceID),
-	}, 5*time.Minute)
-	if waitErr != nil {
-		return fmt.Errorf("failed to wait for SSM command execution: %w", waitErr)
+// containsAll reports whether values contains every element of wanted.
+func containsAll(values []string, wanted []string) bool {
+	for _, value := range wanted {
+		if !slices.Contains(values, value) {
+			return false
+		}
 	}
+	return true
+}
 
-	invocationOutput, err := ssmSvc.GetCommandInvocation(ctx, &ssm.GetCommandInvocationInput{
-		CommandId:  aws.String(commandID),
-		InstanceId: aws.String(instanceID),
+func installTailScale(ctx context.Context, p provider.Provider, region string, nodeName string, instanceID string, spin *spinner.Spinner) error {
+	err := p.Bootstrap(ctx, provider.BootstrapRequest{
+		Region:     region,
+		InstanceID: instanceID,
+		Hostname:   nodeName,
+		Progress:   func(title string) { spin.Title(title) },
 	})
 	if err != nil {
-		return fmt.Errorf("failed to get SSM command invocation: %w", err)
+		return fmt.Errorf("failed to bootstrap instance: %w", err)
 	}
-
-	if invocationOutput.Status != ssmTypes.CommandInvocationStatusSuccess {
-		return fmt.Errorf("SSM command failed with status: %s, output: %s", invocationOutput.Status, aws.ToString(invocationOutput.StandardErrorContent))
-	}
-
 	return nil
 }
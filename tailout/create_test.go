@@ -0,0 +1,98 @@
+package tailout
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/lucacome/tailout/tailout/provider"
+)
+
+// fakeProvider records the launch requests it receives, and fails the spot
+// ones when spot capacity is unavailable.
+type fakeProvider struct {
+	provider.Provider
+
+	spotUnavailable bool
+	launches        []provider.LaunchRequest
+}
+
+func (p *fakeProvider) Launch(_ context.Context, req provider.LaunchRequest) (provider.Instance, error) {
+	// The tags are recorded as launched, before a retry changes them.
+	req.Tags = maps.Clone(req.Tags)
+	p.launches = append(p.launches, req)
+	if p.spotUnavailable && req.Market == provider.MarketSpot {
+		return provider.Instance{}, provider.ErrSpotUnavailable
+	}
+	return provider.Instance{ID: "i-048afd4880f66c596", Market: req.Market, Tags: req.Tags}, nil
+}
+
+func TestCreateInstanceMarket(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		market          string
+		spotUnavailable bool
+		wantLaunches    []string
+		wantErr         error
+	}{
+		{name: "spot", market: provider.MarketSpot, wantLaunches: []string{provider.MarketSpot}},
+		{name: "on-demand", market: provider.MarketOnDemand, wantLaunches: []string{provider.MarketOnDemand}},
+		{name: "spot then on-demand", market: provider.MarketSpotThenOnDemand, wantLaunches: []string{provider.MarketSpot}},
+		{
+			name:            "fallback to on-demand",
+			market:          provider.MarketSpotThenOnDemand,
+			spotUnavailable: true,
+			wantLaunches:    []string{provider.MarketSpot, provider.MarketOnDemand},
+		},
+		{
+			name:            "spot unavailable",
+			market:          provider.MarketSpot,
+			spotUnavailable: true,
+			wantLaunches:    []string{provider.MarketSpot},
+			wantErr:         provider.ErrSpotUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &fakeProvider{spotUnavailable: tt.spotUnavailable}
+			req := &provider.LaunchRequest{Region: "eu-west-3", Market: tt.market, Tags: map[string]string{}}
+			instance, err := createInstance(t.Context(), p, req, spinner.New())
+
+			var markets []string
+			for _, launch := range p.launches {
+				if launch.Tags[provider.TagMarket] != launch.Market {
+					t.Errorf("%s launch tagged with market %q", launch.Market, launch.Tags[provider.TagMarket])
+				}
+				markets = append(markets, launch.Market)
+			}
+			if !slices.Equal(markets, tt.wantLaunches) {
+				t.Errorf("launched %v, want %v", markets, tt.wantLaunches)
+			}
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("createInstance() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("createInstance() unexpected error: %v", err)
+			}
+			want := tt.wantLaunches[len(tt.wantLaunches)-1]
+			if instance.Market != want || instance.Tags[provider.TagMarket] != want {
+				t.Errorf("instance launched as %q and tagged %q, want %q", instance.Market, instance.Tags[provider.TagMarket], want)
+			}
+			if _, ok := req.Tags[provider.TagMarket]; ok {
+				t.Error("createInstance() changed the tags of the request")
+			}
+		})
+	}
+}
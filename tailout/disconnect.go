@@ -3,27 +3,62 @@ package tailout
 import (
 	"context"
 	"fmt"
-	"net/url"
+	"net/netip"
 
 	"github.com/lucacome/tailout/internal"
+	tslocal "tailscale.com/client/local"
 	tsapi "tailscale.com/client/tailscale/v2"
 )
 
 func (app *App) Disconnect(ctx context.Context) error {
-	baseURL, err := url.Parse(app.Config.Tailscale.BaseURL)
+	apiClient, err := app.tailscaleClient(ctx, scopeDevicesRead)
 	if err != nil {
-		return fmt.Errorf("failed to parse base URL: %w", err)
+		return err
 	}
 
-	apiClient := &tsapi.Client{
-		APIKey:  app.Config.Tailscale.APIKey,
-		BaseURL: baseURL,
+	// The embedded node does not outlive connect, only the local tailscaled
+	// can be disconnected.
+	var localClient tslocal.Client
+	return app.disconnect(ctx, &localClient, apiClient)
+}
+
+// disconnect restores the exit node settings that were active before connect.
+// The exit node is cleared instead if there was none or clear is set.
+func (app *App) disconnect(ctx context.Context, localClient *tslocal.Client, apiClient *tsapi.Client) error {
+	state, found, err := internal.LoadExitNodeState()
+	if err != nil {
+		return err
+	}
+
+	if found {
+		message := "Disconnected from exit node, previous exit node settings restored."
+		if app.Config.Disconnect.Clear {
+			// Only the options set along with the exit node are restored.
+			state.ExitNodeID = ""
+			state.ExitNodeIP = netip.Addr{}
+			state.AutoExitNode = ""
+			message = "Disconnected from exit node, previous LAN access and DNS settings restored."
+		}
+
+		err = internal.RestoreExitNode(ctx, localClient, state)
+		if err != nil {
+			return fmt.Errorf("failed to disconnect from exit node: %w", err)
+		}
+		if err := internal.RemoveExitNodeState(); err != nil {
+			return err
+		}
+
+		fmt.Println(message)
+		return nil
 	}
 
-	errUpdate := internal.UpdateExitNode(ctx, apiClient, "")
+	errUpdate := internal.UpdateExitNode(ctx, localClient, apiClient, "", internal.ExitNodeOptions{})
 	if errUpdate != nil {
 		return fmt.Errorf("failed to disconnect from exit node: %w", errUpdate)
 	}
+	if err := internal.RemoveExitNodeState(); err != nil {
+		return err
+	}
 
 	fmt.Println("Disconnected from exit node.")
 	return nil
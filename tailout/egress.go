@@ -0,0 +1,99 @@
+package tailout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lucacome/tailout/internal"
+	"github.com/lucacome/tailout/tailout/provider"
+)
+
+const (
+	defaultEchoURL = "https://ifconfig.me/ip"
+	echoTimeout    = 10 * time.Second
+)
+
+// publicIP returns the public IP address this machine's traffic leaves with,
+// as reported by the echo service.
+func (app *App) publicIP(ctx context.Context) (string, error) {
+	echoURL := app.Config.Egress.EchoURL
+	if echoURL == "" {
+		echoURL = defaultEchoURL
+	}
+	return fetchIP(ctx, app.httpClient(), echoURL)
+}
+
+// expectedEgressIP returns the public IP address traffic leaving through the
+// node should have: the one the node itself reports with node_echo, or else
+// the public IP address of its instance.
+func (app *App) expectedEgressIP(ctx context.Context, node internal.Node) (string, error) {
+	if !app.Config.Egress.NodeEcho {
+		if node.PublicIP() == "" {
+			return "", fmt.Errorf("instance of node %s has no public IP address", node.Hostname())
+		}
+		return node.PublicIP(), nil
+	}
+
+	if node.Address() == "" {
+		return "", fmt.Errorf("node %s has no tailnet address", node.Hostname())
+	}
+	return fetchIP(ctx, app.httpClient(), "http://"+net.JoinHostPort(node.Address(), provider.EgressEchoPort)+"/")
+}
+
+// verifyEgress prints the public IP address of this machine and whether it is
+// the one of the node.
+func (app *App) verifyEgress(ctx context.Context, node internal.Node) error {
+	observed, err := app.publicIP(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Public IP: " + observed)
+
+	expected, err := app.expectedEgressIP(ctx, node)
+	if err != nil {
+		fmt.Println("Egress check: unknown,", err)
+		return nil
+	}
+	if observed != expected {
+		fmt.Printf("Egress check: fail, traffic does not leave through %s (%s)\n", node.Hostname(), expected)
+		return nil
+	}
+	fmt.Printf("Egress check: pass, traffic leaves through %s\n", node.Hostname())
+	return nil
+}
+
+func fetchIP(ctx context.Context, httpClient *http.Client, url string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, echoTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get public IP from %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get public IP from %s: %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", fmt.Errorf("failed to get public IP from %s: %w", url, err)
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return "", errors.New("invalid public IP returned by " + url)
+	}
+	return ip.String(), nil
+}
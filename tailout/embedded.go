@@ -0,0 +1,120 @@
+package tailout
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	tslocal "tailscale.com/client/local"
+	"tailscale.com/tsnet"
+)
+
+const embeddedUpTimeout = 2 * time.Minute
+
+// localClient returns the client of the Tailscale node tailout acts through:
+// the local tailscaled, or in embedded mode a tsnet node started in this
+// process. The returned function stops the embedded node if this call
+// started it.
+func (app *App) localClient(ctx context.Context) (*tslocal.Client, func(), error) {
+	if !app.Config.Embedded.Enabled {
+		return &tslocal.Client{}, func() {}, nil
+	}
+
+	stop := func() {}
+	if app.embedded == nil {
+		if err := app.startEmbedded(ctx); err != nil {
+			return nil, nil, err
+		}
+		stop = app.stopEmbedded
+	}
+
+	lc, err := app.embedded.LocalClient()
+	if err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("failed to get embedded node client: %w", err)
+	}
+	return lc, stop, nil
+}
+
+// startEmbedded starts an ephemeral tsnet node that joins the tailnet with
+// the configured auth key, or with a single-use key tagged tag:tailout-client.
+func (app *App) startEmbedded(ctx context.Context) error {
+	dir := app.Config.Embedded.Dir
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return fmt.Errorf("failed to get cache directory: %w", err)
+		}
+		dir = filepath.Join(cacheDir, "tailout", "tsnet")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create embedded node directory: %w", err)
+	}
+
+	hostname := "tailout-client"
+	if host, err := os.Hostname(); err == nil {
+		hostname += "-" + host
+	}
+
+	srv := &tsnet.Server{
+		Dir:       dir,
+		Hostname:  hostname,
+		Ephemeral: true,
+		AuthKey:   app.Config.Embedded.AuthKey,
+		Logf:      func(string, ...any) {},
+	}
+
+	if srv.AuthKey == "" {
+		apiClient, err := app.tailscaleClient(ctx, scopeAuthKeys)
+		if err != nil {
+			return err
+		}
+		key, err := createAuthKey(ctx, apiClient, hostname, "tag:tailout-client")
+		if err != nil {
+			return err
+		}
+		defer revokeAuthKey(ctx, apiClient, key.ID)
+		srv.AuthKey = key.Key
+	}
+
+	fmt.Printf("Starting embedded node %s...\n", hostname)
+	upCtx, cancel := context.WithTimeout(ctx, embeddedUpTimeout)
+	defer cancel()
+	if _, err := srv.Up(upCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("failed to start embedded node: %w", err)
+	}
+
+	app.embedded = srv
+	return nil
+}
+
+// stopEmbedded logs the embedded node out, which removes it from the tailnet
+// right away, and stops it.
+func (app *App) stopEmbedded() {
+	if app.embedded == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if lc, err := app.embedded.LocalClient(); err == nil {
+		if err := lc.Logout(ctx); err != nil {
+			fmt.Println("Failed to log the embedded node out, it is removed once offline:", err)
+		}
+	}
+	app.embedded.Close()
+	app.embedded = nil
+}
+
+// httpClient returns the HTTP client used to reach the internet, through the
+// embedded node in embedded mode.
+func (app *App) httpClient() *http.Client {
+	if app.embedded != nil {
+		return app.embedded.HTTPClient()
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,130 @@
+package tailout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/lucacome/tailout/internal"
+	"github.com/lucacome/tailout/tailout/provider"
+)
+
+func (app *App) Extend(ctx context.Context, args []string) error {
+	dryRun := app.Config.DryRun
+	shorten := app.Config.Extend.Shorten
+	at := app.Config.Extend.At
+
+	switch {
+	case len(args) == 0:
+		return errors.New("no node name provided")
+	case at == "" && len(args) < 2:
+		return errors.New("a duration or --at is required")
+	case at != "" && len(args) > 1:
+		return errors.New("a duration cannot be combined with --at")
+	case at != "" && shorten:
+		return errors.New("--shorten cannot be combined with --at")
+	}
+
+	client, err := app.tailscaleClient(ctx, scopeDevicesRead)
+	if err != nil {
+		return err
+	}
+
+	p, err := app.cloudProvider()
+	if err != nil {
+		return err
+	}
+
+	nodes, err := app.inventory(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	node, err := internal.SelectNode(nodes, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to select node: %w", err)
+	}
+	if node.Instance == nil {
+		return fmt.Errorf("node %s has no instance", node.Hostname())
+	}
+	if node.Instance.State != "running" {
+		return fmt.Errorf("node %s is %s, only running nodes can be extended", node.Hostname(), node.Instance.State)
+	}
+
+	now := time.Now()
+	current, known := node.ExpiresAt()
+
+	var deadline time.Time
+	if at != "" {
+		deadline, err = parseDeadline(at, now)
+		if err != nil {
+			return err
+		}
+	} else {
+		duration, parseErr := time.ParseDuration(args[1])
+		if parseErr != nil {
+			return fmt.Errorf("failed to parse duration: %w", parseErr)
+		}
+
+		switch {
+		case shorten && !known:
+			return fmt.Errorf("the shutdown deadline of %s is unknown, use --at to set it", node.Hostname())
+		case shorten:
+			deadline = current.Add(-duration)
+		case known && current.After(now):
+			deadline = current.Add(duration)
+		default:
+			deadline = now.Add(duration)
+		}
+	}
+
+	if deadline.Before(now.Add(time.Minute)) {
+		return fmt.Errorf("the new deadline %s must be at least 1 minute in the future", deadline.Format(time.RFC3339))
+	}
+
+	if known {
+		fmt.Printf("Moving the shutdown of %s from %s to %s.\n", node.Hostname(), current.Format(time.RFC3339), deadline.Format(time.RFC3339))
+	} else {
+		fmt.Printf("Scheduling the shutdown of %s at %s.\n", node.Hostname(), deadline.Format(time.RFC3339))
+	}
+
+	if dryRun {
+		fmt.Println("Dry run, not changing the shutdown deadline.")
+		return nil
+	}
+
+	s := spinner.New().Type(spinner.Dots).Title("Updating shutdown deadline...")
+	errSpin := s.Context(ctx).ActionWithErr(func(context.Context) error {
+		return p.Reschedule(ctx, node.Region(), node.InstanceID(), deadline)
+	}).Run()
+	if errors.Is(errSpin, provider.ErrRescheduleUnavailable) {
+		return fmt.Errorf("failed to update shutdown deadline, the deadline of nodes created with --bootstrap user-data can only be changed in accounts where SSM is set up: %w", errSpin)
+	}
+	if errSpin != nil {
+		return fmt.Errorf("failed to update shutdown deadline: %w", errSpin)
+	}
+
+	fmt.Println("Planned termination time:", deadline.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// parseDeadline parses an absolute deadline, either as an RFC 3339 time or as
+// a local time of day, in which case the next occurrence of that time is used.
+func parseDeadline(value string, now time.Time) (time.Time, error) {
+	if deadline, err := time.Parse(time.RFC3339, value); err == nil {
+		return deadline, nil
+	}
+
+	clock, err := time.ParseInLocation("15:04", value, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339 or HH:MM", value)
+	}
+
+	deadline := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !deadline.After(now) {
+		deadline = deadline.AddDate(0, 0, 1)
+	}
+	return deadline, nil
+}
@@ -0,0 +1,71 @@
+package tailout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDeadline(t *testing.T) {
+	t.Parallel()
+
+	loc := time.FixedZone("CET", 3600)
+	now := time.Date(2026, 3, 10, 14, 30, 0, 0, loc)
+
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:  "RFC 3339",
+			value: "2026-03-11T08:00:00Z",
+			want:  time.Date(2026, 3, 11, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "time of day later today",
+			value: "18:45",
+			want:  time.Date(2026, 3, 10, 18, 45, 0, 0, loc),
+		},
+		{
+			name:  "time of day already passed",
+			value: "09:00",
+			want:  time.Date(2026, 3, 11, 9, 0, 0, 0, loc),
+		},
+		{
+			name:  "current time of day",
+			value: "14:30",
+			want:  time.Date(2026, 3, 11, 14, 30, 0, 0, loc),
+		},
+		{
+			name:    "duration",
+			value:   "1h",
+			wantErr: true,
+		},
+		{
+			name:    "invalid time of day",
+			value:   "25:00",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseDeadline(tt.value, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseDeadline(%q) = %s, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDeadline(%q) unexpected error: %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseDeadline(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package tailout
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lucacome/tailout/internal"
+	"github.com/lucacome/tailout/tailout/config"
+	"github.com/lucacome/tailout/tailout/provider"
+	tsapi "tailscale.com/client/tailscale/v2"
+)
+
+// imageBuildGracePeriod is how long an image build can take.
+const imageBuildGracePeriod = time.Hour
+
+// GC finds the instances, devices and auth keys left behind by failed or
+// interrupted tailout runs and removes them.
+func (app *App) GC(ctx context.Context) error {
+	nonInteractive := app.Config.NonInteractive
+	dryRun := app.Config.DryRun
+
+	gracePeriod, err := time.ParseDuration(cmp.Or(app.Config.GC.GracePeriod, config.DefaultGCGracePeriod))
+	if err != nil {
+		return fmt.Errorf("failed to parse grace period: %w", err)
+	}
+
+	client, err := app.tailscaleClient(ctx, scopeDevices, scopeAuthKeys)
+	if err != nil {
+		return err
+	}
+
+	p, err := app.cloudProvider()
+	if err != nil {
+		return err
+	}
+
+	// Devices are orphans only if the instances of every region were listed.
+	nodes, err := internal.GetInventory(ctx, p, client)
+	if err != nil {
+		return fmt.Errorf("failed to get tailout nodes: %w", err)
+	}
+
+	keys, err := client.Keys().List(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to list auth keys: %w", err)
+	}
+
+	// Resources created less than gracePeriod ago may belong to a create that
+	// is still in progress, so they are left alone.
+	cutoff := time.Now().Add(-gracePeriod)
+
+	// Image builders never join the tailnet, they are only orphaned once the
+	// build is over.
+	builderCutoff := time.Now().Add(-imageBuildGracePeriod)
+
+	orphanInstances := []internal.Node{}
+	orphanDevices := []internal.Node{}
+	for _, node := range nodes {
+		switch {
+		case node.ImageBuilder() && node.Instance.LaunchTime.After(builderCutoff):
+		case node.Device == nil && node.Instance.LaunchTime.Before(cutoff):
+			orphanInstances = append(orphanInstances, node)
+		case node.Instance == nil && offlineSince(*node.Device, cutoff):
+			orphanDevices = append(orphanDevices, node)
+		}
+	}
+
+	regionNames, err := internal.GetRegions(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	unusedNetworks := map[string][]string{}
+	for _, region := range regionNames {
+		released, releaseErr := p.ReleaseNetwork(ctx, region, true)
+		if releaseErr != nil {
+			return fmt.Errorf("failed to check the tailout network in %s: %w", region, releaseErr)
+		}
+		if len(released) > 0 {
+			unusedNetworks[region] = released
+		}
+	}
+
+	orphanKeys := []tsapi.Key{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key.Description, "tailout") {
+			continue
+		}
+		// Listed keys do not carry their full details.
+		fullKey, getErr := client.Keys().Get(ctx, key.ID)
+		if getErr != nil {
+			return fmt.Errorf("failed to get auth key %s: %w", key.ID, getErr)
+		}
+		if isUnusedTailoutKey(*fullKey, cutoff) {
+			orphanKeys = append(orphanKeys, *fullKey)
+		}
+	}
+
+	if len(orphanInstances) == 0 && len(orphanDevices) == 0 && len(orphanKeys) == 0 && len(unusedNetworks) == 0 {
+		fmt.Println("Nothing to clean up.")
+		return nil
+	}
+
+	fmt.Println("The following orphaned resources will be removed:")
+	for _, node := range orphanInstances {
+		fmt.Printf("- instance %s in %s (%s, no tailnet device, launched %s)\n", node.InstanceID(), node.Region(), node.Instance.State, node.Instance.LaunchTime.Format(time.RFC3339))
+	}
+	for _, node := range orphanDevices {
+		fmt.Printf("- device %s (%s, no instance, last seen %s)\n", node.Hostname(), node.Address(), node.Device.LastSeen.Format(time.RFC3339))
+	}
+	for _, key := range orphanKeys {
+		fmt.Printf("- auth key %s (%s, created %s)\n", key.ID, key.Description, key.Created.Format(time.RFC3339))
+	}
+	for region, released := range unusedNetworks {
+		fmt.Printf("- unused tailout network in %s (%s)\n", region, strings.Join(released, ", "))
+	}
+	if len(orphanInstances) > 0 {
+		fmt.Println("Tailout networks left empty by the terminated instances will be removed as well.")
+	}
+
+	if dryRun {
+		fmt.Println("Dry run, not removing anything.")
+		return nil
+	}
+
+	if !nonInteractive {
+		result, promptErr := internal.PromptYesNo(ctx, "Do you want to remove these resources?")
+		if promptErr != nil {
+			return fmt.Errorf("failed to prompt for confirmation: %w", promptErr)
+		}
+
+		if !result {
+			fmt.Println("Aborting...")
+			return nil
+		}
+	}
+
+	// Keep going on failures so that one stuck resource does not prevent the
+	// others from being cleaned up.
+	var errs []error
+	for _, node := range orphanInstances {
+		removed, err := p.Terminate(ctx, node.Region(), []string{node.InstanceID()}, false)
+		if len(removed) > 0 {
+			fmt.Println("Terminated instance", node.InstanceID()+", removed", strings.Join(removed, ", "))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to terminate instance %s: %w", node.InstanceID(), err))
+		}
+	}
+	for _, node := range orphanDevices {
+		if err := client.Devices().Delete(ctx, node.Device.ID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete device %s: %w", node.Hostname(), err))
+			continue
+		}
+		fmt.Println("Deleted device", node.Hostname())
+	}
+	for _, key := range orphanKeys {
+		if err := client.Keys().Delete(ctx, key.ID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to revoke auth key %s: %w", key.ID, err))
+			continue
+		}
+		fmt.Println("Revoked auth key", key.ID)
+	}
+
+	// Release the networks that no instance uses anymore, like the ones of a
+	// create or a stop that was interrupted.
+	if err := releaseNetworks(ctx, p, regionNames); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// isUnusedTailoutKey reports whether key is an auth key created by tailout
+// before cutoff that is still valid. Tailout keys are single use, so a key
+// that is still valid was never used to join a node.
+func isUnusedTailoutKey(key tsapi.Key, cutoff time.Time) bool {
+	if !strings.HasPrefix(key.Description, "tailout") {
+		return false
+	}
+	if key.KeyType != "" && key.KeyType != "auth" {
+		return false
+	}
+	// Only the keys made by createAuthKey are tailout's, whatever their
+	// description.
+	create := key.Capabilities.Devices.Create
+	if create.Reusable || !create.Ephemeral || !create.Preauthorized {
+		return false
+	}
+	if !slices.Contains(create.Tags, "tag:tailout") && !slices.Contains(create.Tags, "tag:tailout-client") {
+		return false
+	}
+	if key.Invalid || !key.Revoked.IsZero() {
+		return false
+	}
+	if !key.Expires.IsZero() && key.Expires.Before(time.Now()) {
+		return false
+	}
+	return key.Created.Before(cutoff)
+}
+
+// offlineSince reports whether the device was created and last connected to
+// the tailnet before cutoff. A device that is online, or was recently, may
+// belong to an instance that is still starting or was not listed.
+func offlineSince(device tsapi.Device, cutoff time.Time) bool {
+	if device.ConnectedToControl || device.LastSeen == nil {
+		return false
+	}
+	return device.Created.Before(cutoff) && device.LastSeen.Before(cutoff)
+}
+
+// releaseNetworks deletes the dedicated tailout networks of the regions that
+// have no tailout instance left.
+func releaseNetworks(ctx context.Context, p provider.Provider, regions []string) error {
+	for _, region := range regions {
+		released, err := p.ReleaseNetwork(ctx, region, false)
+		if err != nil {
+			return fmt.Errorf("failed to release the tailout network in %s: %w", region, err)
+		}
+		if len(released) > 0 {
+			fmt.Printf("Deleted the tailout network in %s: %s\n", region, strings.Join(released, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package tailout
+
+import (
+	"testing"
+	"time"
+
+	tsapi "tailscale.com/client/tailscale/v2"
+)
+
+// tailoutKey returns an auth key as created by createAuthKey, changed by edit.
+func tailoutKey(created time.Time, edit func(*tsapi.Key)) tsapi.Key {
+	key := tsapi.Key{
+		ID:          "k123",
+		KeyType:     "auth",
+		Description: "tailout-eu-west-3-i-048afd4880f66c596",
+		Created:     created,
+		Expires:     created.Add(authKeyExpiry),
+	}
+	key.Capabilities.Devices.Create.Ephemeral = true
+	key.Capabilities.Devices.Create.Preauthorized = true
+	key.Capabilities.Devices.Create.Tags = []string{"tag:tailout"}
+	if edit != nil {
+		edit(&key)
+	}
+	return key
+}
+
+func TestIsUnusedTailoutKey(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	cutoff := now.Add(-10 * time.Minute)
+	old := now.Add(-20 * time.Minute)
+
+	if key := tailoutKey(old, nil); !isUnusedTailoutKey(key, cutoff) {
+		t.Errorf("unused tailout key %s created at %s is not collected", key.Description, key.Created)
+	}
+
+	kept := map[string]tsapi.Key{
+		"created after the cutoff": tailoutKey(now.Add(-time.Minute), nil),
+		"other description":        tailoutKey(old, func(k *tsapi.Key) { k.Description = "ci runner" }),
+		"API key":                  tailoutKey(old, func(k *tsapi.Key) { k.KeyType = "api" }),
+		"reusable":                 tailoutKey(old, func(k *tsapi.Key) { k.Capabilities.Devices.Create.Reusable = true }),
+		"not ephemeral":            tailoutKey(old, func(k *tsapi.Key) { k.Capabilities.Devices.Create.Ephemeral = false }),
+		"not preauthorized":        tailoutKey(old, func(k *tsapi.Key) { k.Capabilities.Devices.Create.Preauthorized = false }),
+		"other tag":                tailoutKey(old, func(k *tsapi.Key) { k.Capabilities.Devices.Create.Tags = []string{"tag:ci"} }),
+		"used":                     tailoutKey(old, func(k *tsapi.Key) { k.Invalid = true }),
+		"revoked":                  tailoutKey(old, func(k *tsapi.Key) { k.Revoked = now.Add(-15 * time.Minute) }),
+		"expired":                  tailoutKey(old, func(k *tsapi.Key) { k.Expires = now.Add(-time.Minute) }),
+	}
+	for name, key := range kept {
+		if isUnusedTailoutKey(key, cutoff) {
+			t.Errorf("%s key is collected", name)
+		}
+	}
+}
+
+func TestOfflineSince(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	cutoff := now.Add(-time.Hour)
+	old := tsapi.Time{Time: now.Add(-2 * time.Hour)}
+	recent := tsapi.Time{Time: now.Add(-time.Minute)}
+
+	if !offlineSince(tsapi.Device{Created: old, LastSeen: &old}, cutoff) {
+		t.Error("device offline since before the cutoff is not collected")
+	}
+
+	kept := map[string]tsapi.Device{
+		"online":                   {Created: old, ConnectedToControl: true},
+		"seen after the cutoff":    {Created: old, LastSeen: &recent},
+		"created after the cutoff": {Created: recent, LastSeen: &recent},
+		"never seen":               {Created: old},
+	}
+	for name, device := range kept {
+		if offlineSince(device, cutoff) {
+			t.Errorf("%s device is collected", name)
+		}
+	}
+}
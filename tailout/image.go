@@ -0,0 +1,226 @@
+package tailout
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/lucacome/tailout/internal"
+	"github.com/lucacome/tailout/tailout/config"
+	"github.com/lucacome/tailout/tailout/provider"
+)
+
+// ImageBuild builds a tailout image with Tailscale installed, which create
+// then launches nodes from.
+func (app *App) ImageBuild(ctx context.Context) error {
+	nonInteractive := app.Config.NonInteractive
+	region := app.Config.Region
+	instanceType := cmp.Or(app.Config.Image.InstanceType, config.DefaultInstanceType)
+
+	// The builder is launched where the nodes are, so that an account
+	// without a default network can build images.
+	network, err := app.createNetwork()
+	if err != nil {
+		return err
+	}
+
+	p, err := app.cloudProvider()
+	if err != nil {
+		return err
+	}
+
+	if region == "" && !nonInteractive {
+		region, err = internal.SelectRegion(ctx, p)
+		if err != nil {
+			return fmt.Errorf("failed to select region: %w", err)
+		}
+	} else if region == "" && nonInteractive {
+		return errors.New("selected non-interactive mode but no region was explicitly specified")
+	}
+
+	if !nonInteractive {
+		fmt.Printf("A builder instance of type %s will be launched in %s to build the image.\n", instanceType, region)
+		fmt.Println("Network:", networkDescription(network))
+		result, promptErr := internal.PromptYesNo(ctx, "Do you want to build the image?")
+		if promptErr != nil {
+			return fmt.Errorf("failed to prompt for confirmation: %w", promptErr)
+		}
+		if !result {
+			fmt.Println("Aborting...")
+			return nil
+		}
+	}
+
+	var image provider.Image
+	s := spinner.New().Type(spinner.Dots).Title("Building image...")
+	errSpin := s.Context(ctx).ActionWithErr(func(context.Context) error {
+		var buildErr error
+		image, buildErr = p.BuildImage(ctx, provider.ImageBuildRequest{
+			Region:       region,
+			InstanceType: instanceType,
+			Network:      network,
+			Progress:     func(title string) { s.Title(title) },
+		})
+		return buildErr //nolint:wrapcheck // wrapped below
+	}).Run()
+	if errSpin != nil {
+		return fmt.Errorf("failed to build image: %w", errSpin)
+	}
+
+	fmt.Printf("Image %s (%s, %s) is available in %s.\n", image.ID, image.Name, image.Architecture, region)
+	return nil
+}
+
+// ImageList lists the tailout images of the configured region, or of every
+// region if none is configured.
+func (app *App) ImageList(ctx context.Context) error {
+	p, err := app.cloudProvider()
+	if err != nil {
+		return err
+	}
+
+	regions, images, err := app.regionImages(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, region := range regions {
+		if len(images[i]) == 0 {
+			continue
+		}
+		found = true
+		fmt.Printf("Images in %s:\n", region)
+		latest := map[string]bool{}
+		for _, image := range images[i] {
+			label := ""
+			if !latest[image.Architecture] {
+				latest[image.Architecture] = true
+				label = " [used by create]"
+			}
+			fmt.Printf("- %s (%s, %s, created %s)%s\n", image.ID, image.Name, image.Architecture, image.Created.Local().Format(time.DateTime), label)
+		}
+	}
+	if !found {
+		fmt.Println("No tailout image found.")
+	}
+	return nil
+}
+
+// ImagePrune deletes the tailout images older than the newest ones of each
+// region and architecture.
+func (app *App) ImagePrune(ctx context.Context) error {
+	nonInteractive := app.Config.NonInteractive
+	dryRun := app.Config.DryRun
+	keep := app.Config.Image.Keep
+
+	if keep < 1 {
+		return errors.New("at least 1 image must be kept")
+	}
+
+	p, err := app.cloudProvider()
+	if err != nil {
+		return err
+	}
+
+	regions, images, err := app.regionImages(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	// Images are sorted newest first, so the images past the first keep of
+	// each architecture are the old ones.
+	toDelete := make([][]provider.Image, len(regions))
+	count := 0
+	for i := range regions {
+		kept := map[string]int{}
+		for _, image := range images[i] {
+			if kept[image.Architecture] < keep {
+				kept[image.Architecture]++
+				continue
+			}
+			toDelete[i] = append(toDelete[i], image)
+			count++
+		}
+	}
+
+	if count == 0 {
+		fmt.Println("No image to prune.")
+		return nil
+	}
+
+	fmt.Println("The following images will be deleted:")
+	for i, region := range regions {
+		for _, image := range toDelete[i] {
+			fmt.Printf("- %s in %s (%s, %s, created %s)\n", image.ID, region, image.Name, image.Architecture, image.Created.Local().Format(time.DateTime))
+		}
+	}
+
+	if dryRun {
+		fmt.Println("Dry run, not deleting anything.")
+		return nil
+	}
+
+	if !nonInteractive {
+		result, promptErr := internal.PromptYesNo(ctx, "Do you want to delete these images?")
+		if promptErr != nil {
+			return fmt.Errorf("failed to prompt for confirmation: %w", promptErr)
+		}
+
+		if !result {
+			fmt.Println("Aborting...")
+			return nil
+		}
+	}
+
+	var errs []error
+	for i, region := range regions {
+		for _, image := range toDelete[i] {
+			if err := p.DeleteImage(ctx, region, image.ID); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			fmt.Println("Deleted image", image.ID)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// regionImages returns the tailout images of the configured region, or of
+// every region in parallel if none is configured.
+func (app *App) regionImages(ctx context.Context, p provider.Provider) ([]string, [][]provider.Image, error) {
+	regions := []string{app.Config.Region}
+	if app.Config.Region == "" {
+		var err error
+		regions, err = internal.GetRegions(ctx, p)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		images = make([][]provider.Image, len(regions))
+		errs   = make([]error, len(regions))
+	)
+	for i, region := range regions {
+		wg.Go(func() {
+			regionImages, listErr := p.Images(ctx, region)
+			if listErr != nil {
+				errs[i] = fmt.Errorf("failed to list images in %s: %w", region, listErr)
+				return
+			}
+			images[i] = regionImages
+		})
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+
+	return regions, images, nil
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/url"
+	"slices"
 
 	"github.com/lucacome/tailout/internal"
+	"github.com/lucacome/tailout/tailout/provider"
 	tsapi "tailscale.com/client/tailscale/v2"
 )
 
@@ -14,14 +15,9 @@ func (app *App) Init(ctx context.Context) error {
 	dryRun := app.Config.DryRun
 	nonInteractive := app.Config.NonInteractive
 
-	baseURL, err := url.Parse(app.Config.Tailscale.BaseURL)
+	apiClient, err := app.tailscaleClient(ctx, scopePolicyFile)
 	if err != nil {
-		return fmt.Errorf("failed to parse base URL: %w", err)
-	}
-
-	apiClient := &tsapi.Client{
-		APIKey:  app.Config.Tailscale.APIKey,
-		BaseURL: baseURL,
+		return err
 	}
 
 	// Get the ACL configuration
@@ -37,7 +33,23 @@ func (app *App) Init(ctx context.Context) error {
 		Users:       []string{"autogroup:nonroot", "root"},
 	}
 
+	// The embedded node of tailout uses tailout nodes as exit nodes.
+	allowTailoutClient := tsapi.ACLEntry{
+		Action:      "accept",
+		Source:      []string{"tag:tailout-client"},
+		Destination: []string{"autogroup:internet:*", "tag:tailout:*"},
+	}
+
+	// Connect and status compare the public IP address with the one the
+	// nodes serve on the tailnet.
+	allowEgressEcho := tsapi.ACLEntry{
+		Action:      "accept",
+		Source:      []string{"autogroup:member"},
+		Destination: []string{"tag:tailout:" + provider.EgressEchoPort},
+	}
+
 	tailoutSSHConfigExists, tailoutTagExists, tailoutAutoApproversExists := false, false, false
+	tailoutClientTagExists, tailoutClientACLExists, egressEchoACLExists := false, false, false
 
 	for _, sshConfig := range acl.SSH {
 		if sshConfig.Action == "check" && sshConfig.Source[0] == "autogroup:member" && sshConfig.Destination[0] == "tag:tailout" && sshConfig.Users[0] == "autogroup:nonroot" && sshConfig.Users[1] == "root" {
@@ -52,6 +64,14 @@ func (app *App) Init(ctx context.Context) error {
 		acl.TagOwners["tag:tailout"] = []string{}
 	}
 
+	// The embedded node of tailout joins with this tag.
+	if acl.TagOwners["tag:tailout-client"] != nil {
+		fmt.Println("Tag 'tag:tailout-client' already exists.")
+		tailoutClientTagExists = true
+	} else {
+		acl.TagOwners["tag:tailout-client"] = []string{}
+	}
+
 	if acl.AutoApprovers == nil {
 		fmt.Println("Auto approvers configuration does not exist.")
 		acl.AutoApprovers = &tsapi.ACLAutoApprovers{}
@@ -74,7 +94,21 @@ func (app *App) Init(ctx context.Context) error {
 		acl.SSH = append(acl.SSH, allowTailoutSSH)
 	}
 
-	if tailoutTagExists && tailoutAutoApproversExists && tailoutSSHConfigExists && !dryRun {
+	if hasACLEntry(acl.ACLs, allowTailoutClient) {
+		fmt.Println("ACL for tag:tailout-client nodes already exists.")
+		tailoutClientACLExists = true
+	} else {
+		acl.ACLs = append(acl.ACLs, allowTailoutClient)
+	}
+
+	if hasACLEntry(acl.ACLs, allowEgressEcho) {
+		fmt.Println("ACL for the egress check of tailout nodes already exists.")
+		egressEchoACLExists = true
+	} else {
+		acl.ACLs = append(acl.ACLs, allowEgressEcho)
+	}
+
+	if tailoutTagExists && tailoutClientTagExists && tailoutAutoApproversExists && tailoutSSHConfigExists && tailoutClientACLExists && egressEchoACLExists && !dryRun {
 		fmt.Println("Nothing to do.")
 		return nil
 	}
@@ -94,13 +128,15 @@ func (app *App) Init(ctx context.Context) error {
 	// Make a prompt to show the update that will be done
 	fmt.Printf(`
 The following update to the acl will be done:
-- Add tag:tailout to tagOwners
+- Add tag:tailout and tag:tailout-client to tagOwners
 - Update auto approvers to allow exit nodes tagged with tag:tailout
 - Add a SSH configuration allowing users to SSH into tagged tailout nodes
+- Add an ACL allowing tag:tailout-client nodes to use tailout exit nodes
+- Add an ACL allowing members to reach the egress check served by tailout nodes on port %s
 
 Your new acl document will look like this:
 %s
-`, aclJSON)
+`, provider.EgressEchoPort, aclJSON)
 
 	if !dryRun {
 		if !nonInteractive {
@@ -126,3 +162,11 @@ Your new acl document will look like this:
 	}
 	return nil
 }
+
+// hasACLEntry reports whether the ACL entries contain one with the same
+// action, sources and destinations as entry.
+func hasACLEntry(entries []tsapi.ACLEntry, entry tsapi.ACLEntry) bool {
+	return slices.ContainsFunc(entries, func(e tsapi.ACLEntry) bool {
+		return e.Action == entry.Action && slices.Equal(e.Source, entry.Source) && slices.Equal(e.Destination, entry.Destination)
+	})
+}
@@ -0,0 +1,604 @@
+// Package aws implements the tailout provider for Amazon Web Services.
+package aws
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/lucacome/tailout/tailout/provider"
+)
+
+// Name is the name the AWS provider is registered with.
+const Name = "aws"
+
+func init() {
+	provider.Register(Name, New)
+}
+
+// Provider manages tailout nodes as EC2 instances.
+type Provider struct{}
+
+// New returns a new AWS provider.
+func New() (provider.Provider, error) {
+	return &Provider{}, nil
+}
+
+// Name returns the name of the provider.
+func (p *Provider) Name() string {
+	return Name
+}
+
+func loadConfig(ctx context.Context, region string) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region), config.WithRetryMaxAttempts(5), config.WithRetryMode(aws.RetryModeStandard))
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Regions returns the sorted list of regions enabled for the account.
+func (p *Provider) Regions(ctx context.Context) ([]string, error) {
+	cfg, err := loadConfig(ctx, "us-east-1")
+	if err != nil {
+		return nil, err
+	}
+	ec2Svc := ec2.NewFromConfig(cfg)
+
+	regions, err := ec2Svc.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe regions: %w", err)
+	}
+
+	regionNames := []string{}
+	for _, region := range regions.Regions {
+		regionNames = append(regionNames, *region.RegionName)
+	}
+
+	sort.Strings(regionNames)
+
+	return regionNames, nil
+}
+
+// Account returns the AWS account ID of the caller.
+func (p *Provider) Account(ctx context.Context, region string) (string, error) {
+	cfg, err := loadConfig(ctx, region)
+	if err != nil {
+		return "", err
+	}
+	stsSvc := sts.NewFromConfig(cfg)
+
+	identity, err := stsSvc.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get account ID: %w", err)
+	}
+	return aws.ToString(identity.Account), nil
+}
+
+// LookupImage returns the newest tailout AMI of the region for the
+// architecture of the instance type, or the latest Amazon Linux 2023 AMI if
+// no tailout AMI was built.
+func (p *Provider) LookupImage(ctx context.Context, region, instanceType string) (provider.Image, error) {
+	cfg, err := loadConfig(ctx, region)
+	if err != nil {
+		return provider.Image{}, err
+	}
+	ec2Svc := ec2.NewFromConfig(cfg)
+
+	architecture, err := instanceArchitecture(ctx, ec2Svc, instanceType)
+	if err != nil {
+		return provider.Image{}, err
+	}
+
+	images, err := tailoutImages(ctx, ec2Svc, architecture)
+	if err != nil {
+		return provider.Image{}, err
+	}
+	if len(images) > 0 {
+		return images[0], nil
+	}
+
+	return amazonLinuxImage(ctx, ec2Svc, architecture)
+}
+
+// amazonLinuxImage returns the latest Amazon Linux 2023 AMI of the region for the architecture.
+func amazonLinuxImage(ctx context.Context, ec2Svc *ec2.Client, architecture types.ArchitectureType) (provider.Image, error) {
+	// DescribeImages to get the latest Amazon Linux AMI
+	amazonLinuxImages, err := ec2Svc.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("name"),
+				Values: []string{"al2023-ami-*"},
+			},
+			{
+				Name:   aws.String("state"),
+				Values: []string{"available"},
+			},
+			{
+				Name:   aws.String("is-public"),
+				Values: []string{"true"},
+			},
+			{
+				Name:   aws.String("architecture"),
+				Values: []string{string(architecture)},
+			},
+		},
+		Owners: []string{"amazon"},
+	})
+	if err != nil {
+		return provider.Image{}, fmt.Errorf("failed to describe Amazon Linux images: %w", err)
+	}
+
+	if len(amazonLinuxImages.Images) == 0 {
+		return provider.Image{}, fmt.Errorf("no Amazon Linux images found for architecture %s", architecture)
+	}
+
+	sort.Slice(amazonLinuxImages.Images, func(i, j int) bool {
+		return *amazonLinuxImages.Images[i].CreationDate > *amazonLinuxImages.Images[j].CreationDate
+	})
+
+	// Get the latest Amazon Linux AMI
+	return toImage(amazonLinuxImages.Images[0]), nil
+}
+
+// instanceArchitecture returns the architecture of the AMIs that can run on
+// the instance type, preferring x86_64 when both are supported.
+func instanceArchitecture(ctx context.Context, ec2Svc *ec2.Client, instanceType string) (types.ArchitectureType, error) {
+	output, err := ec2Svc.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(instanceType)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe instance type %s: %w", instanceType, err)
+	}
+
+	if len(output.InstanceTypes) == 0 || output.InstanceTypes[0].ProcessorInfo == nil {
+		return "", fmt.Errorf("instance type %s is not available", instanceType)
+	}
+
+	architectures := output.InstanceTypes[0].ProcessorInfo.SupportedArchitectures
+	for _, architecture := range []types.ArchitectureType{types.ArchitectureTypeX8664, types.ArchitectureTypeArm64} {
+		if slices.Contains(architectures, architecture) {
+			return architecture, nil
+		}
+	}
+
+	return "", fmt.Errorf("instance type %s has no supported architecture: %v", instanceType, architectures)
+}
+
+// spotUnavailableCodes are the RunInstances error codes returned when a spot
+// request cannot be fulfilled.
+var spotUnavailableCodes = []string{
+	"InsufficientInstanceCapacity",
+	"InsufficientCapacity",
+	"SpotMaxPriceTooLow",
+	"MaxSpotInstanceCountExceeded",
+	"UnfulfillableCapacity",
+}
+
+// Launch runs an instance tagged with App=tailout and waits for it to be running.
+func (p *Provider) Launch(ctx context.Context, req provider.LaunchRequest) (provider.Instance, error) {
+	var instance provider.Instance
+
+	cfg, err := loadConfig(ctx, req.Region)
+	if err != nil {
+		return instance, err
+	}
+	ec2Svc := ec2.NewFromConfig(cfg)
+
+	// TODO: Fix shutdown time
+	userDataScript := `#!/bin/bash
+# Allow ip forwarding
+echo 'net.ipv4.ip_forward = 1' | sudo tee -a /etc/sysctl.conf
+echo 'net.ipv6.conf.all.forwarding = 1' | sudo tee -a /etc/sysctl.conf
+sudo sysctl -p /etc/sysctl.conf`
+	if req.ShutdownAfter != "" {
+		userDataScript += `
+sudo echo "sudo shutdown" | at now + ` + req.ShutdownAfter + ` minutes`
+	}
+	hostname := cmp.Or(req.Hostname, "tailout-"+req.Region+"-$INSTANCE_ID")
+	switch {
+	case req.AuthKey != "" && req.Bootstrap == provider.BootstrapUserData:
+		// The hostname matches the Name tag given to the instance once it is created.
+		userDataScript += `
+# Install Tailscale and join the tailnet
+TOKEN=$(curl -s -X PUT http://169.254.169.254/latest/api/token -H "X-aws-ec2-metadata-token-ttl-seconds: 300")
+INSTANCE_ID=$(curl -s -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/meta-data/instance-id)
+command -v tailscale >/dev/null || curl -fsSL https://tailscale.com/install.sh | sh
+sudo tailscale up --auth-key=` + req.AuthKey + ` --hostname=` + hostname + ` --advertise-exit-node --ssh
+` + strings.Join(egressEchoCommands, "\n")
+	case req.AuthKey != "":
+		// Bootstrap reads the key from the user data, so that it does not
+		// appear in the SSM command history.
+		userDataScript += `
+` + authKeyPrefix + req.AuthKey
+	}
+
+	// Encode the string in base64
+	userDataScriptBase64 := base64.StdEncoding.EncodeToString([]byte(userDataScript))
+
+	blockDeviceMappings, err := deleteOnTermination(ctx, ec2Svc, req.Image.ID)
+	if err != nil {
+		return instance, err
+	}
+
+	// Create instance input parameters. The instance terminates when it shuts
+	// itself down and takes its volumes with it, so that nothing keeps billing
+	// once the deadline has passed.
+	runInput := &ec2.RunInstancesInput{
+		ImageId:                           aws.String(req.Image.ID),
+		InstanceType:                      types.InstanceType(req.InstanceType),
+		MinCount:                          aws.Int32(1),
+		MaxCount:                          aws.Int32(1),
+		UserData:                          aws.String(userDataScriptBase64),
+		InstanceInitiatedShutdownBehavior: types.ShutdownBehaviorTerminate,
+		BlockDeviceMappings:               blockDeviceMappings,
+		// Only allow IMDSv2, as the user data can hold the auth key.
+		MetadataOptions: &types.InstanceMetadataOptionsRequest{
+			HttpEndpoint: types.InstanceMetadataEndpointStateEnabled,
+			HttpTokens:   types.HttpTokensStateRequired,
+		},
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeInstance,
+				Tags:         instanceTags(req.Tags),
+			},
+			{
+				ResourceType: types.ResourceTypeVolume,
+				Tags:         instanceTags(nil),
+			},
+			{
+				ResourceType: types.ResourceTypeNetworkInterface,
+				Tags:         instanceTags(nil),
+			},
+		},
+		DryRun: aws.Bool(req.DryRun),
+	}
+
+	netInterface, err := networkInterface(ctx, ec2Svc, req.Network, req.DryRun, req.Progress)
+	if err != nil {
+		return instance, err
+	}
+	if netInterface != nil {
+		runInput.NetworkInterfaces = []types.InstanceNetworkInterfaceSpecification{*netInterface}
+	} else {
+		runInput.SecurityGroupIds = req.Network.SecurityGroupIDs
+	}
+
+	switch req.Market {
+	case provider.MarketSpot:
+		runInput.InstanceMarketOptions = &types.InstanceMarketOptionsRequest{
+			MarketType: types.MarketTypeSpot,
+			SpotOptions: &types.SpotMarketOptions{
+				InstanceInterruptionBehavior: types.InstanceInterruptionBehaviorTerminate,
+			},
+		}
+		if req.SpotMaxPrice != "" {
+			runInput.InstanceMarketOptions.SpotOptions.MaxPrice = aws.String(req.SpotMaxPrice)
+		}
+	case provider.MarketOnDemand:
+	default:
+		return instance, fmt.Errorf("unsupported market %q", req.Market)
+	}
+
+	// Run the EC2 instance
+	runResult, runErr := ec2Svc.RunInstances(ctx, runInput)
+	if runErr != nil {
+		var dryRunErr *smithy.GenericAPIError
+		if errors.As(runErr, &dryRunErr) && dryRunErr.Code == "DryRunOperation" {
+			return instance, nil
+		}
+		var apiErr smithy.APIError
+		if req.Market == provider.MarketSpot && errors.As(runErr, &apiErr) && slices.Contains(spotUnavailableCodes, apiErr.ErrorCode()) {
+			return instance, fmt.Errorf("failed to create EC2 instance: %w: %w", provider.ErrSpotUnavailable, runErr)
+		}
+		return instance, fmt.Errorf("failed to create EC2 instance: %w", runErr)
+	}
+
+	if len(runResult.Instances) == 0 {
+		fmt.Println("No instances created.")
+		return instance, nil
+	}
+	createdInstance := runResult.Instances[0]
+
+	fmt.Println("Instance created:", *createdInstance.InstanceId)
+
+	nodeName := cmp.Or(req.Hostname, fmt.Sprintf("tailout-%s-%s", req.Region, *createdInstance.InstanceId))
+	// Create tags for the instance
+	tags := []types.Tag{
+		{
+			Key:   aws.String("Name"),
+			Value: aws.String(nodeName),
+		},
+	}
+
+	// Add the tags to the instance
+	_, err = ec2Svc.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{*createdInstance.InstanceId},
+		Tags:      tags,
+	})
+	if err != nil {
+		return instance, fmt.Errorf("failed to add tags to the instance: %w", err)
+	}
+
+	provider.Report(req.Progress, "Waiting for instance to be running...")
+	err = ec2.NewInstanceStatusOkWaiter(ec2Svc).Wait(ctx, &ec2.DescribeInstanceStatusInput{
+		InstanceIds: []string{*createdInstance.InstanceId},
+	}, time.Minute*5)
+	if err != nil {
+		return instance, fmt.Errorf("failed to wait for instance to be created: %w", err)
+	}
+
+	described, err := p.Describe(ctx, req.Region, *createdInstance.InstanceId)
+	if err != nil {
+		return instance, err
+	}
+	if len(described) == 0 {
+		return instance, errors.New("no instances found")
+	}
+
+	instance = described[0]
+	if instance.PublicIP == "" {
+		return instance, errors.New("no public IP address found")
+	}
+	instance.Name = nodeName
+
+	return instance, nil
+}
+
+// authKeyPrefix starts the user data comment line holding the auth key read
+// by Bootstrap.
+const authKeyPrefix = "# tailout-auth-key: "
+
+// Bootstrap installs Tailscale on the instance with an SSM command. The
+// command reads the auth key from the user data of the instance with IMDSv2,
+// so that the key does not appear in the command history and the instance
+// needs no permission to read it.
+func (p *Provider) Bootstrap(ctx context.Context, req provider.BootstrapRequest) error {
+	cfg, err := loadConfig(ctx, req.Region)
+	if err != nil {
+		return err
+	}
+
+	provider.Report(req.Progress, "Installing Tailscale...")
+	commands := []string{
+		"echo 'Installing Tailscale...'",
+		"command -v tailscale >/dev/null || curl -fsSL https://tailscale.com/install.sh | sh",
+		"echo 'Reading the auth key...'",
+		`TOKEN=$(curl -fsS -X PUT http://169.254.169.254/latest/api/token -H "X-aws-ec2-metadata-token-ttl-seconds: 300")`,
+		`AUTH_KEY=$(curl -fsS -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/user-data | sed -n 's/^` + authKeyPrefix + `//p')`,
+		`if [ -z "$AUTH_KEY" ]; then echo 'No auth key found in the user data' >&2; exit 1; fi`,
+		"echo 'Starting Tailscale...'",
+		`sudo tailscale up --auth-key="$AUTH_KEY" --hostname=` + req.Hostname + " --advertise-exit-node --ssh",
+		"echo 'Serving the egress IP address...'",
+	}
+	commands = append(commands, egressEchoCommands...)
+	commands = append(commands, "echo 'Tailscale installation and configuration completed.'")
+	return runCommand(ctx, cfg, req.InstanceID, commands)
+}
+
+// egressEchoCommands make the node serve the public IP address its traffic
+// leaves with on the tailnet, so clients can check they egress through it.
+var egressEchoCommands = []string{
+	"sudo mkdir -p /var/lib/tailout",
+	"curl -fsS https://checkip.amazonaws.com | sudo tee /var/lib/tailout/egress-ip >/dev/null",
+	"sudo tailscale serve --bg --http=" + provider.EgressEchoPort + " /var/lib/tailout/egress-ip",
+}
+
+// Reschedule replaces the shutdown job of the instance so that it shuts down
+// at the deadline, and records the deadline in the tailout:expires-at tag. The
+// tailout:persistent tag is removed, as the instance now has a deadline.
+func (p *Provider) Reschedule(ctx context.Context, region, instanceID string, deadline time.Time) error {
+	cfg, err := loadConfig(ctx, region)
+	if err != nil {
+		return err
+	}
+
+	minutes := int(math.Ceil(time.Until(deadline).Minutes()))
+	if minutes < 1 {
+		return errors.New("deadline must be at least 1 minute in the future")
+	}
+
+	// The shutdown is scheduled on the instance itself, which can only be
+	// reached through SSM.
+	online, err := ssmOnline(ctx, cfg, instanceID)
+	if err != nil {
+		return err
+	}
+	if !online {
+		return fmt.Errorf("%w: instance %s is not managed by SSM, check its instance profile and SSM agent", provider.ErrRescheduleUnavailable, instanceID)
+	}
+
+	err = runCommand(ctx, cfg, instanceID, []string{
+		"for job in $(sudo atq | cut -f1); do sudo atrm \"$job\"; done",
+		"echo 'sudo shutdown' | sudo at now + " + strconv.Itoa(minutes) + " minutes",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reschedule shutdown: %w", err)
+	}
+
+	ec2Svc := ec2.NewFromConfig(cfg)
+	_, err = ec2Svc.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{instanceID},
+		Tags: []types.Tag{
+			{
+				Key:   aws.String(provider.TagExpiresAt),
+				Value: aws.String(deadline.UTC().Format(time.RFC3339)),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record the new deadline: %w", err)
+	}
+
+	_, err = ec2Svc.DeleteTags(ctx, &ec2.DeleteTagsInput{
+		Resources: []string{instanceID},
+		Tags: []types.Tag{
+			{
+				Key: aws.String(provider.TagPersistent),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove the persistent tag: %w", err)
+	}
+	return nil
+}
+
+// ssmOnline reports whether the SSM agent of the instance is registered and online.
+func ssmOnline(ctx context.Context, cfg aws.Config, instanceID string) (bool, error) {
+	ssmSvc := ssm.NewFromConfig(cfg)
+	output, err := ssmSvc.DescribeInstanceInformation(ctx, &ssm.DescribeInstanceInformationInput{
+		Filters: []ssmTypes.InstanceInformationStringFilter{
+			{
+				Key:    aws.String("InstanceIds"),
+				Values: []string{instanceID},
+			},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe SSM managed instances: %w", err)
+	}
+	for _, info := range output.InstanceInformationList {
+		if aws.ToString(info.InstanceId) == instanceID && info.PingStatus == ssmTypes.PingStatusOnline {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// runCommand runs shell commands on the instance with SSM and waits for them to succeed.
+func runCommand(ctx context.Context, cfg aws.Config, instanceID string, commands []string) error {
+	ssmSvc := ssm.NewFromConfig(cfg)
+
+	input := &ssm.SendCommandInput{
+		InstanceIds:  []string{instanceID},
+		DocumentName: aws.String("AWS-RunShellScript"),
+		Parameters: map[string][]string{
+			"commands": commands,
+		},
+	}
+
+	output, err := ssmSvc.SendCommand(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to send SSM command: %w", err)
+	}
+
+	commandID := *output.Command.CommandId
+	waiter := ssm.NewCommandExecutedWaiter(ssmSvc)
+	waitErr := waiter.Wait(ctx, &ssm.GetCommandInvocationInput{
+		CommandId:  aws.String(commandID),
+		InstanceId: aws.String(instanceID),
+	}, 5*time.Minute)
+	if waitErr != nil {
+		return fmt.Errorf("failed to wait for SSM command execution: %w", waitErr)
+	}
+
+	invocationOutput, err := ssmSvc.GetCommandInvocation(ctx, &ssm.GetCommandInvocationInput{
+		CommandId:  aws.String(commandID),
+		InstanceId: aws.String(instanceID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get SSM command invocation: %w", err)
+	}
+
+	if invocationOutput.Status != ssmTypes.CommandInvocationStatusSuccess {
+		return fmt.Errorf("SSM command failed with status: %s, output: %s", invocationOutput.Status, aws.ToString(invocationOutput.StandardErrorContent))
+	}
+
+	return nil
+}
+
+// Describe returns the instances tagged with App=tailout in the region.
+func (p *Provider) Describe(ctx context.Context, region string, instanceIDs ...string) ([]provider.Instance, error) {
+	cfg, err := loadConfig(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	ec2Svc := ec2.NewFromConfig(cfg)
+
+	input := &ec2.DescribeInstancesInput{
+		InstanceIds: instanceIDs,
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:App"),
+				Values: []string{"tailout"},
+			},
+		},
+	}
+
+	instances := []provider.Instance{}
+	paginator := ec2.NewDescribeInstancesPaginator(ec2Svc, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe EC2 instances: %w", err)
+		}
+		for _, reservation := range page.Reservations {
+			for _, i := range reservation.Instances {
+				instances = append(instances, toInstance(region, i))
+			}
+		}
+	}
+
+	return instances, nil
+}
+
+// instanceTags returns the tags of a new instance: App=tailout and the
+// tailout metadata of the request, in a stable order.
+func instanceTags(extra map[string]string) []types.Tag {
+	tags := []types.Tag{
+		{
+			Key:   aws.String("App"),
+			Value: aws.String("tailout"),
+		},
+	}
+	for _, key := range slices.Sorted(maps.Keys(extra)) {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(extra[key]),
+		})
+	}
+	return tags
+}
+
+func toInstance(region string, i types.Instance) provider.Instance {
+	tags := make(map[string]string, len(i.Tags))
+	for _, tag := range i.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	instance := provider.Instance{
+		ID:           aws.ToString(i.InstanceId),
+		Name:         tags["Name"],
+		Region:       region,
+		PublicIP:     aws.ToString(i.PublicIpAddress),
+		InstanceType: string(i.InstanceType),
+		Market:       provider.MarketOnDemand,
+		LaunchTime:   aws.ToTime(i.LaunchTime),
+		Tags:         tags,
+	}
+	if i.State != nil {
+		instance.State = string(i.State.Name)
+	}
+	if i.InstanceLifecycle == types.InstanceLifecycleTypeSpot {
+		instance.Market = provider.MarketSpot
+	}
+	return instance
+}
@@ -0,0 +1,211 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lucacome/tailout/tailout/provider"
+)
+
+// imageBuildCommands install Tailscale on the builder instance and tune it
+// for exit nodes. The state of tailscaled is removed last, so that every
+// node launched from the image gets its own node key.
+var imageBuildCommands = []string{
+	"set -e",
+	"curl -fsSL https://tailscale.com/install.sh | sh",
+	"dnf install -y ethtool",
+	"printf 'net.ipv4.ip_forward = 1\\nnet.ipv6.conf.all.forwarding = 1\\n' > /etc/sysctl.d/99-tailscale.conf",
+	// UDP GRO forwarding improves the throughput of exit nodes, see
+	// https://tailscale.com/kb/1320/performance-best-practices
+	"printf '[Unit]\\nDescription=Tune UDP GRO forwarding for Tailscale\\nAfter=network-online.target\\nWants=network-online.target\\n\\n" +
+		"[Service]\\nType=oneshot\\nExecStart=/bin/sh -c \"ethtool -K $$(ip -o route get 8.8.8.8 | cut -f 5 -d \\\\\" \\\\\") rx-udp-gro-forwarding on rx-gro-list off\"\\n\\n" +
+		"[Install]\\nWantedBy=multi-user.target\\n' > /etc/systemd/system/tailscale-udp-gro.service",
+	"systemctl daemon-reload",
+	"systemctl enable tailscale-udp-gro.service tailscaled",
+	"systemctl stop tailscaled",
+	"rm -rf /var/lib/tailscale/*",
+}
+
+// BuildImage launches a builder instance from the latest Amazon Linux 2023
+// AMI, installs Tailscale on it with SSM and creates a tailout AMI from it.
+// The builder instance is terminated once the AMI is available.
+func (p *Provider) BuildImage(ctx context.Context, req provider.ImageBuildRequest) (provider.Image, error) {
+	cfg, err := loadConfig(ctx, req.Region)
+	if err != nil {
+		return provider.Image{}, err
+	}
+	ec2Svc := ec2.NewFromConfig(cfg)
+
+	architecture, err := instanceArchitecture(ctx, ec2Svc, req.InstanceType)
+	if err != nil {
+		return provider.Image{}, err
+	}
+
+	base, err := amazonLinuxImage(ctx, ec2Svc, architecture)
+	if err != nil {
+		return provider.Image{}, err
+	}
+
+	provider.Report(req.Progress, "Launching the builder instance...")
+	builder, err := p.Launch(ctx, provider.LaunchRequest{
+		Region:       req.Region,
+		Image:        base,
+		InstanceType: req.InstanceType,
+		Network:      req.Network,
+		Market:       provider.MarketOnDemand,
+		Tags: map[string]string{
+			provider.TagImageBuilder: "true",
+		},
+		Progress: req.Progress,
+	})
+	if builder.ID != "" {
+		// Terminate the builder even if the build is interrupted.
+		defer func() {
+			removed, terminateErr := p.Terminate(context.WithoutCancel(ctx), req.Region, []string{builder.ID}, false)
+			if terminateErr != nil {
+				fmt.Println("Failed to terminate the builder instance", builder.ID+":", terminateErr)
+				return
+			}
+			fmt.Println("Terminated the builder instance, removed", strings.Join(removed, ", "))
+		}()
+	}
+	if err != nil {
+		return provider.Image{}, fmt.Errorf("failed to launch the builder instance: %w", err)
+	}
+
+	provider.Report(req.Progress, "Installing Tailscale on the builder instance...")
+	err = runCommand(ctx, cfg, builder.ID, imageBuildCommands)
+	if err != nil {
+		return provider.Image{}, fmt.Errorf("failed to install Tailscale on the builder instance: %w", err)
+	}
+
+	provider.Report(req.Progress, "Creating the image...")
+	name := fmt.Sprintf("tailout-%s-%s", architecture, time.Now().UTC().Format("20060102-150405"))
+	output, err := ec2Svc.CreateImage(ctx, &ec2.CreateImageInput{
+		InstanceId:  aws.String(builder.ID),
+		Name:        aws.String(name),
+		Description: aws.String("tailout exit node with Tailscale installed"),
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeImage,
+				Tags:         instanceTags(map[string]string{"Name": name}),
+			},
+			{
+				ResourceType: types.ResourceTypeSnapshot,
+				Tags:         instanceTags(map[string]string{"Name": name}),
+			},
+		},
+	})
+	if err != nil {
+		return provider.Image{}, fmt.Errorf("failed to create image: %w", err)
+	}
+	imageID := aws.ToString(output.ImageId)
+
+	provider.Report(req.Progress, "Waiting for the image to be available...")
+	err = ec2.NewImageAvailableWaiter(ec2Svc).Wait(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{imageID},
+	}, 30*time.Minute)
+	if err != nil {
+		return provider.Image{}, fmt.Errorf("failed to wait for image %s to be available: %w", imageID, err)
+	}
+
+	described, err := ec2Svc.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{imageID},
+	})
+	if err != nil {
+		return provider.Image{}, fmt.Errorf("failed to describe image %s: %w", imageID, err)
+	}
+	if len(described.Images) == 0 {
+		return provider.Image{}, errors.New("no images found")
+	}
+
+	return toImage(described.Images[0]), nil
+}
+
+// Images returns the tailout AMIs of the region, newest first.
+func (p *Provider) Images(ctx context.Context, region string) ([]provider.Image, error) {
+	cfg, err := loadConfig(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	return tailoutImages(ctx, ec2.NewFromConfig(cfg), "")
+}
+
+// DeleteImage deregisters a tailout AMI and deletes its snapshots.
+func (p *Provider) DeleteImage(ctx context.Context, region, imageID string) error {
+	cfg, err := loadConfig(ctx, region)
+	if err != nil {
+		return err
+	}
+	ec2Svc := ec2.NewFromConfig(cfg)
+
+	_, err = ec2Svc.DeregisterImage(ctx, &ec2.DeregisterImageInput{
+		ImageId:                   aws.String(imageID),
+		DeleteAssociatedSnapshots: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deregister image %s: %w", imageID, err)
+	}
+	return nil
+}
+
+// tailoutImages returns the available tailout AMIs owned by the account,
+// newest first. If architecture is empty, the AMIs of every architecture are
+// returned.
+func tailoutImages(ctx context.Context, ec2Svc *ec2.Client, architecture types.ArchitectureType) ([]provider.Image, error) {
+	filters := []types.Filter{
+		tailoutFilter(),
+		{
+			Name:   aws.String("state"),
+			Values: []string{"available"},
+		},
+	}
+	if architecture != "" {
+		filters = append(filters, types.Filter{
+			Name:   aws.String("architecture"),
+			Values: []string{string(architecture)},
+		})
+	}
+
+	output, err := ec2Svc.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners:  []string{"self"},
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe tailout images: %w", err)
+	}
+
+	images := make([]provider.Image, 0, len(output.Images))
+	for _, image := range output.Images {
+		images = append(images, toImage(image))
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Created.After(images[j].Created)
+	})
+	return images, nil
+}
+
+func toImage(i types.Image) provider.Image {
+	owner := aws.ToString(i.ImageOwnerAlias)
+	if owner == "" {
+		owner = aws.ToString(i.OwnerId)
+	}
+
+	// A creation date that cannot be parsed is left as the zero time.
+	created, _ := time.Parse(time.RFC3339, aws.ToString(i.CreationDate))
+
+	return provider.Image{
+		ID:           aws.ToString(i.ImageId),
+		Name:         aws.ToString(i.Name),
+		Owner:        owner,
+		Architecture: string(i.Architecture),
+		Created:      created,
+	}
+}
@@ -0,0 +1,482 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/lucacome/tailout/tailout/provider"
+)
+
+const (
+	// networkName is the Name tag of the resources of the dedicated network.
+	networkName = "tailout"
+	vpcCIDR     = "10.64.0.0/16"
+	subnetCIDR  = "10.64.0.0/24"
+	// tailscalePort is the UDP port tailscaled listens on for direct connections.
+	tailscalePort = 41641
+)
+
+// tailoutFilter matches the resources tagged with App=tailout.
+func tailoutFilter() types.Filter {
+	return types.Filter{
+		Name:   aws.String("tag:App"),
+		Values: []string{"tailout"},
+	}
+}
+
+func vpcFilter(vpcID string) types.Filter {
+	return types.Filter{
+		Name:   aws.String("vpc-id"),
+		Values: []string{vpcID},
+	}
+}
+
+func tagSpecifications(resourceType types.ResourceType) []types.TagSpecification {
+	return []types.TagSpecification{
+		{
+			ResourceType: resourceType,
+			Tags: []types.Tag{
+				{
+					Key:   aws.String("App"),
+					Value: aws.String("tailout"),
+				},
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(networkName),
+				},
+			},
+		},
+	}
+}
+
+// networkInterface returns the network interface instances are launched with
+// when a subnet is selected, so that they always get a public IP address.
+func networkInterface(ctx context.Context, ec2Svc *ec2.Client, network provider.Network, dryRun bool, progress func(string)) (*types.InstanceNetworkInterfaceSpecification, error) {
+	subnetID := network.SubnetID
+	securityGroupIDs := network.SecurityGroupIDs
+
+	switch {
+	case network.Dedicated && dryRun:
+		// Nothing can be created in dry run mode, validate the request against
+		// the default network instead.
+		fmt.Println("Dry run, not setting up the tailout network.")
+		return nil, nil //nolint:nilnil // launch in the default network
+	case network.Dedicated:
+		var err error
+		subnetID, securityGroupIDs, err = ensureNetwork(ctx, ec2Svc, progress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up the tailout network: %w", err)
+		}
+	case subnetID == "" && network.VPCID != "":
+		var err error
+		subnetID, err = vpcSubnet(ctx, ec2Svc, network.VPCID)
+		if err != nil {
+			return nil, err
+		}
+	case subnetID == "":
+		return nil, nil //nolint:nilnil // launch in the default network
+	}
+
+	return &types.InstanceNetworkInterfaceSpecification{
+		DeviceIndex:              aws.Int32(0),
+		SubnetId:                 aws.String(subnetID),
+		Groups:                   securityGroupIDs,
+		AssociatePublicIpAddress: aws.Bool(true),
+		DeleteOnTermination:      aws.Bool(true),
+	}, nil
+}
+
+// vpcSubnet returns a subnet of the VPC, preferring the ones that assign
+// public IP addresses on launch.
+func vpcSubnet(ctx context.Context, ec2Svc *ec2.Client, vpcID string) (string, error) {
+	output, err := ec2Svc.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		Filters: []types.Filter{vpcFilter(vpcID)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe subnets of %s: %w", vpcID, err)
+	}
+
+	if len(output.Subnets) == 0 {
+		return "", fmt.Errorf("no subnet found in %s", vpcID)
+	}
+
+	for _, subnet := range output.Subnets {
+		if aws.ToBool(subnet.MapPublicIpOnLaunch) {
+			return aws.ToString(subnet.SubnetId), nil
+		}
+	}
+	return aws.ToString(output.Subnets[0].SubnetId), nil
+}
+
+// ensureNetwork creates the dedicated tailout VPC of the region, or reuses
+// it if it already exists, and returns its public subnet and security group.
+func ensureNetwork(ctx context.Context, ec2Svc *ec2.Client, progress func(string)) (string, []string, error) {
+	provider.Report(progress, "Setting up the tailout network...")
+
+	vpc, err := findTailoutVPC(ctx, ec2Svc)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var vpcID string
+	if vpc != nil {
+		vpcID = aws.ToString(vpc.VpcId)
+	} else {
+		output, createErr := ec2Svc.CreateVpc(ctx, &ec2.CreateVpcInput{
+			CidrBlock:         aws.String(vpcCIDR),
+			TagSpecifications: tagSpecifications(types.ResourceTypeVpc),
+		})
+		if createErr != nil {
+			return "", nil, fmt.Errorf("failed to create VPC: %w", createErr)
+		}
+		vpcID = aws.ToString(output.Vpc.VpcId)
+		fmt.Println("Created VPC", vpcID)
+
+		err = ec2.NewVpcAvailableWaiter(ec2Svc).Wait(ctx, &ec2.DescribeVpcsInput{
+			VpcIds: []string{vpcID},
+		}, 2*time.Minute)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to wait for VPC to be available: %w", err)
+		}
+	}
+
+	if err := ensureInternetGateway(ctx, ec2Svc, vpcID); err != nil {
+		return "", nil, err
+	}
+
+	subnetID, err := ensureSubnet(ctx, ec2Svc, vpcID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	securityGroupID, err := ensureSecurityGroup(ctx, ec2Svc, vpcID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return subnetID, []string{securityGroupID}, nil
+}
+
+func findTailoutVPC(ctx context.Context, ec2Svc *ec2.Client) (*types.Vpc, error) {
+	output, err := ec2Svc.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{
+		Filters: []types.Filter{tailoutFilter()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe VPCs: %w", err)
+	}
+
+	if len(output.Vpcs) == 0 {
+		return nil, nil //nolint:nilnil // no tailout VPC in the region
+	}
+	return &output.Vpcs[0], nil
+}
+
+// ensureInternetGateway attaches an internet gateway to the VPC and routes
+// the default route of its main route table through it.
+func ensureInternetGateway(ctx context.Context, ec2Svc *ec2.Client, vpcID string) error {
+	gateways, err := ec2Svc.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("attachment.vpc-id"),
+				Values: []string{vpcID},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe internet gateways: %w", err)
+	}
+
+	var gatewayID string
+	if len(gateways.InternetGateways) > 0 {
+		gatewayID = aws.ToString(gateways.InternetGateways[0].InternetGatewayId)
+	} else {
+		output, createErr := ec2Svc.CreateInternetGateway(ctx, &ec2.CreateInternetGatewayInput{
+			TagSpecifications: tagSpecifications(types.ResourceTypeInternetGateway),
+		})
+		if createErr != nil {
+			return fmt.Errorf("failed to create internet gateway: %w", createErr)
+		}
+		gatewayID = aws.ToString(output.InternetGateway.InternetGatewayId)
+
+		_, err = ec2Svc.AttachInternetGateway(ctx, &ec2.AttachInternetGatewayInput{
+			InternetGatewayId: aws.String(gatewayID),
+			VpcId:             aws.String(vpcID),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to attach internet gateway: %w", err)
+		}
+		fmt.Println("Created internet gateway", gatewayID)
+	}
+
+	routeTables, err := ec2Svc.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []types.Filter{
+			vpcFilter(vpcID),
+			{
+				Name:   aws.String("association.main"),
+				Values: []string{"true"},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe route tables: %w", err)
+	}
+	if len(routeTables.RouteTables) == 0 {
+		return fmt.Errorf("no main route table found in %s", vpcID)
+	}
+
+	_, err = ec2Svc.CreateRoute(ctx, &ec2.CreateRouteInput{
+		RouteTableId:         routeTables.RouteTables[0].RouteTableId,
+		DestinationCidrBlock: aws.String("0.0.0.0/0"),
+		GatewayId:            aws.String(gatewayID),
+	})
+	if err != nil && !isErrorCode(err, "RouteAlreadyExists") {
+		return fmt.Errorf("failed to create default route: %w", err)
+	}
+
+	return nil
+}
+
+func ensureSubnet(ctx context.Context, ec2Svc *ec2.Client, vpcID string) (string, error) {
+	subnets, err := ec2Svc.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		Filters: []types.Filter{vpcFilter(vpcID), tailoutFilter()},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe subnets: %w", err)
+	}
+	if len(subnets.Subnets) > 0 {
+		return aws.ToString(subnets.Subnets[0].SubnetId), nil
+	}
+
+	output, err := ec2Svc.CreateSubnet(ctx, &ec2.CreateSubnetInput{
+		VpcId:             aws.String(vpcID),
+		CidrBlock:         aws.String(subnetCIDR),
+		TagSpecifications: tagSpecifications(types.ResourceTypeSubnet),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create subnet: %w", err)
+	}
+	subnetID := aws.ToString(output.Subnet.SubnetId)
+
+	_, err = ec2Svc.ModifySubnetAttribute(ctx, &ec2.ModifySubnetAttributeInput{
+		SubnetId:            aws.String(subnetID),
+		MapPublicIpOnLaunch: &types.AttributeBooleanValue{Value: aws.Bool(true)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to enable public IP addresses on subnet: %w", err)
+	}
+	fmt.Println("Created subnet", subnetID)
+
+	return subnetID, nil
+}
+
+// ensureSecurityGroup returns the tailout security group of the VPC. It only
+// allows inbound Tailscale traffic, so that nodes can establish direct
+// connections without exposing anything else.
+func ensureSecurityGroup(ctx context.Context, ec2Svc *ec2.Client, vpcID string) (string, error) {
+	groups, err := ec2Svc.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{vpcFilter(vpcID), tailoutFilter()},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe security groups: %w", err)
+	}
+	if len(groups.SecurityGroups) > 0 {
+		return aws.ToString(groups.SecurityGroups[0].GroupId), nil
+	}
+
+	output, err := ec2Svc.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+		GroupName:         aws.String(networkName),
+		Description:       aws.String("tailout nodes, only allows inbound Tailscale traffic"),
+		VpcId:             aws.String(vpcID),
+		TagSpecifications: tagSpecifications(types.ResourceTypeSecurityGroup),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create security group: %w", err)
+	}
+	groupID := aws.ToString(output.GroupId)
+
+	_, err = ec2Svc.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId: aws.String(groupID),
+		IpPermissions: []types.IpPermission{
+			{
+				IpProtocol: aws.String("udp"),
+				FromPort:   aws.Int32(tailscalePort),
+				ToPort:     aws.Int32(tailscalePort),
+				IpRanges:   []types.IpRange{{CidrIp: aws.String("0.0.0.0/0"), Description: aws.String("Tailscale")}},
+				Ipv6Ranges: []types.Ipv6Range{{CidrIpv6: aws.String("::/0"), Description: aws.String("Tailscale")}},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to allow Tailscale traffic in security group: %w", err)
+	}
+	fmt.Println("Created security group", groupID)
+
+	return groupID, nil
+}
+
+// ReleaseNetwork deletes the dedicated tailout VPC of the region once no
+// instance is left in it.
+func (p *Provider) ReleaseNetwork(ctx context.Context, region string, dryRun bool) ([]string, error) {
+	cfg, err := loadConfig(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	ec2Svc := ec2.NewFromConfig(cfg)
+
+	vpc, err := findTailoutVPC(ctx, ec2Svc)
+	if err != nil || vpc == nil {
+		return nil, err
+	}
+	vpcID := aws.ToString(vpc.VpcId)
+
+	instances, err := ec2Svc.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			vpcFilter(vpcID),
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"pending", "running", "shutting-down", "stopping", "stopped"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe instances of %s: %w", vpcID, err)
+	}
+
+	shuttingDown := []string{}
+	for _, reservation := range instances.Reservations {
+		for _, instance := range reservation.Instances {
+			if instance.State == nil || instance.State.Name != types.InstanceStateNameShuttingDown {
+				// The network is still in use.
+				return nil, nil
+			}
+			shuttingDown = append(shuttingDown, aws.ToString(instance.InstanceId))
+		}
+	}
+
+	groups, err := ec2Svc.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{vpcFilter(vpcID), tailoutFilter()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe security groups: %w", err)
+	}
+	subnets, err := ec2Svc.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		Filters: []types.Filter{vpcFilter(vpcID)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe subnets: %w", err)
+	}
+	gateways, err := ec2Svc.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("attachment.vpc-id"),
+				Values: []string{vpcID},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe internet gateways: %w", err)
+	}
+
+	if dryRun {
+		released := []string{}
+		for _, group := range groups.SecurityGroups {
+			released = append(released, aws.ToString(group.GroupId))
+		}
+		for _, subnet := range subnets.Subnets {
+			released = append(released, aws.ToString(subnet.SubnetId))
+		}
+		for _, gateway := range gateways.InternetGateways {
+			released = append(released, aws.ToString(gateway.InternetGatewayId))
+		}
+		return append(released, vpcID), nil
+	}
+
+	if len(shuttingDown) > 0 {
+		err = ec2.NewInstanceTerminatedWaiter(ec2Svc).Wait(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: shuttingDown,
+		}, 5*time.Minute)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wait for instances of %s to terminate: %w", vpcID, err)
+		}
+	}
+
+	released := []string{}
+	for _, group := range groups.SecurityGroups {
+		groupID := aws.ToString(group.GroupId)
+		err = retryDependencyViolation(ctx, func() error {
+			_, deleteErr := ec2Svc.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{GroupId: aws.String(groupID)})
+			return deleteErr //nolint:wrapcheck // wrapped below
+		})
+		if err != nil {
+			return released, fmt.Errorf("failed to delete security group %s: %w", groupID, err)
+		}
+		released = append(released, groupID)
+	}
+
+	for _, subnet := range subnets.Subnets {
+		subnetID := aws.ToString(subnet.SubnetId)
+		err = retryDependencyViolation(ctx, func() error {
+			_, deleteErr := ec2Svc.DeleteSubnet(ctx, &ec2.DeleteSubnetInput{SubnetId: aws.String(subnetID)})
+			return deleteErr //nolint:wrapcheck // wrapped below
+		})
+		if err != nil {
+			return released, fmt.Errorf("failed to delete subnet %s: %w", subnetID, err)
+		}
+		released = append(released, subnetID)
+	}
+
+	for _, gateway := range gateways.InternetGateways {
+		gatewayID := aws.ToString(gateway.InternetGatewayId)
+		_, err = ec2Svc.DetachInternetGateway(ctx, &ec2.DetachInternetGatewayInput{
+			InternetGatewayId: aws.String(gatewayID),
+			VpcId:             aws.String(vpcID),
+		})
+		if err != nil {
+			return released, fmt.Errorf("failed to detach internet gateway %s: %w", gatewayID, err)
+		}
+		_, err = ec2Svc.DeleteInternetGateway(ctx, &ec2.DeleteInternetGatewayInput{InternetGatewayId: aws.String(gatewayID)})
+		if err != nil {
+			return released, fmt.Errorf("failed to delete internet gateway %s: %w", gatewayID, err)
+		}
+		released = append(released, gatewayID)
+	}
+
+	_, err = ec2Svc.DeleteVpc(ctx, &ec2.DeleteVpcInput{VpcId: aws.String(vpcID)})
+	if err != nil {
+		return released, fmt.Errorf("failed to delete VPC %s: %w", vpcID, err)
+	}
+
+	return append(released, vpcID), nil
+}
+
+// retryDependencyViolation retries fn while it fails because of resources
+// that are still being released, like the network interfaces of instances
+// that just terminated.
+func retryDependencyViolation(ctx context.Context, fn func() error) error {
+	const attempts = 12
+	var err error
+	for range attempts {
+		err = fn()
+		if err == nil || !isErrorCode(err, "DependencyViolation") {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("operation canceled: %w", ctx.Err())
+		case <-time.After(10 * time.Second):
+		}
+	}
+	return err
+}
+
+// isErrorCode reports whether err is an AWS API error with the given code.
+func isErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
@@ -0,0 +1,302 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// deleteOnTermination returns the block device mappings of the image with
+// DeleteOnTermination set on every EBS volume.
+func deleteOnTermination(ctx context.Context, ec2Svc *ec2.Client, imageID string) ([]types.BlockDeviceMapping, error) {
+	output, err := ec2Svc.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{imageID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe image %s: %w", imageID, err)
+	}
+	if len(output.Images) == 0 {
+		return nil, fmt.Errorf("image %s not found", imageID)
+	}
+
+	mappings := []types.BlockDeviceMapping{}
+	for _, mapping := range output.Images[0].BlockDeviceMappings {
+		if mapping.Ebs == nil {
+			continue
+		}
+		mappings = append(mappings, types.BlockDeviceMapping{
+			DeviceName: mapping.DeviceName,
+			Ebs: &types.EbsBlockDevice{
+				DeleteOnTermination: aws.Bool(true),
+			},
+		})
+	}
+	return mappings, nil
+}
+
+// Terminate terminates the given EC2 instances and waits for them to be
+// terminated. The volumes and network interfaces that outlive the instances
+// are deleted, as is the dedicated network once no instance uses it, and
+// Terminate fails if any resource tagged App=tailout is left behind.
+func (p *Provider) Terminate(ctx context.Context, region string, instanceIDs []string, dryRun bool) ([]string, error) {
+	cfg, err := loadConfig(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	ec2Svc := ec2.NewFromConfig(cfg)
+
+	volumeIDs, interfaceIDs, err := attachedResources(ctx, ec2Svc, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ec2Svc.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		DryRun:      aws.Bool(dryRun),
+		InstanceIds: instanceIDs,
+	})
+	if dryRun && isErrorCode(err, "DryRunOperation") {
+		return slices.Concat(instanceIDs, volumeIDs, interfaceIDs), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to terminate instance: %w", err)
+	}
+
+	err = ec2.NewInstanceTerminatedWaiter(ec2Svc).Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: instanceIDs,
+	}, 5*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for instances to terminate: %w", err)
+	}
+	removed := slices.Clone(instanceIDs)
+
+	// Volumes and network interfaces deleted with the instances can take a
+	// moment to disappear, and the ones that are kept are only deleted once
+	// they are detached.
+	const attempts = 12
+	var leftovers []string
+	for attempt := range attempts {
+		leftovers, err = deleteLeftovers(ctx, ec2Svc, volumeIDs, interfaceIDs)
+		if err != nil {
+			return removed, err
+		}
+		if len(leftovers) == 0 || attempt == attempts-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return removed, fmt.Errorf("operation canceled: %w", ctx.Err())
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	for _, id := range slices.Concat(volumeIDs, interfaceIDs) {
+		if !slices.Contains(leftovers, id) {
+			removed = append(removed, id)
+		}
+	}
+	if len(leftovers) > 0 {
+		return removed, fmt.Errorf("resources still exist after termination: %s", strings.Join(leftovers, ", "))
+	}
+
+	// The instances may have been the last ones of the dedicated network.
+	released, err := p.ReleaseNetwork(ctx, region, false)
+	removed = append(removed, released...)
+	if err != nil {
+		return removed, fmt.Errorf("failed to release the tailout network: %w", err)
+	}
+
+	leftovers, err = taggedLeftovers(ctx, ec2Svc)
+	if err != nil {
+		return removed, err
+	}
+	if len(leftovers) > 0 {
+		return removed, fmt.Errorf("tailout resources still exist after termination: %s", strings.Join(leftovers, ", "))
+	}
+
+	return removed, nil
+}
+
+// taggedLeftovers returns the resources tagged App=tailout that no instance
+// uses: detached volumes and network interfaces, and the security groups and
+// subnets of a dedicated network that was released.
+func taggedLeftovers(ctx context.Context, ec2Svc *ec2.Client) ([]string, error) {
+	leftovers := []string{}
+	available := types.Filter{
+		Name:   aws.String("status"),
+		Values: []string{"available"},
+	}
+
+	volumes, err := ec2Svc.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		Filters: []types.Filter{tailoutFilter(), available},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe volumes: %w", err)
+	}
+	for _, volume := range volumes.Volumes {
+		leftovers = append(leftovers, aws.ToString(volume.VolumeId))
+	}
+
+	interfaces, err := ec2Svc.DescribeNetworkInterfaces(ctx, &ec2.DescribeNetworkInterfacesInput{
+		Filters: []types.Filter{tailoutFilter(), available},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe network interfaces: %w", err)
+	}
+	for _, netInterface := range interfaces.NetworkInterfaces {
+		leftovers = append(leftovers, aws.ToString(netInterface.NetworkInterfaceId))
+	}
+
+	// The security groups and subnets of a dedicated network that is still
+	// in use are kept for the next instances.
+	vpc, err := findTailoutVPC(ctx, ec2Svc)
+	if err != nil {
+		return nil, err
+	}
+	if vpc != nil {
+		return leftovers, nil
+	}
+
+	groups, err := ec2Svc.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{tailoutFilter()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe security groups: %w", err)
+	}
+	for _, group := range groups.SecurityGroups {
+		leftovers = append(leftovers, aws.ToString(group.GroupId))
+	}
+
+	subnets, err := ec2Svc.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{
+		Filters: []types.Filter{tailoutFilter()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe subnets: %w", err)
+	}
+	for _, subnet := range subnets.Subnets {
+		leftovers = append(leftovers, aws.ToString(subnet.SubnetId))
+	}
+
+	return leftovers, nil
+}
+
+// deleteLeftovers deletes the detached volumes and network interfaces among
+// the given ones, and returns the IDs of the ones that still exist.
+func deleteLeftovers(ctx context.Context, ec2Svc *ec2.Client, volumeIDs, interfaceIDs []string) ([]string, error) {
+	leftovers := []string{}
+
+	volumes, err := remainingVolumes(ctx, ec2Svc, volumeIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, volume := range volumes {
+		volumeID := aws.ToString(volume.VolumeId)
+		if volume.State != types.VolumeStateAvailable {
+			leftovers = append(leftovers, volumeID)
+			continue
+		}
+		_, err = ec2Svc.DeleteVolume(ctx, &ec2.DeleteVolumeInput{VolumeId: aws.String(volumeID)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete volume %s: %w", volumeID, err)
+		}
+	}
+
+	interfaces, err := remainingInterfaces(ctx, ec2Svc, interfaceIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, netInterface := range interfaces {
+		interfaceID := aws.ToString(netInterface.NetworkInterfaceId)
+		if netInterface.Status != types.NetworkInterfaceStatusAvailable {
+			leftovers = append(leftovers, interfaceID)
+			continue
+		}
+		_, err = ec2Svc.DeleteNetworkInterface(ctx, &ec2.DeleteNetworkInterfaceInput{NetworkInterfaceId: aws.String(interfaceID)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete network interface %s: %w", interfaceID, err)
+		}
+	}
+
+	return leftovers, nil
+}
+
+// attachedResources returns the volumes and network interfaces attached to the instances.
+func attachedResources(ctx context.Context, ec2Svc *ec2.Client, instanceIDs []string) ([]string, []string, error) {
+	output, err := ec2Svc.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: instanceIDs,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to describe instances: %w", err)
+	}
+
+	volumeIDs := []string{}
+	interfaceIDs := []string{}
+	for _, reservation := range output.Reservations {
+		for _, instance := range reservation.Instances {
+			for _, mapping := range instance.BlockDeviceMappings {
+				if mapping.Ebs != nil {
+					volumeIDs = append(volumeIDs, aws.ToString(mapping.Ebs.VolumeId))
+				}
+			}
+			for _, netInterface := range instance.NetworkInterfaces {
+				interfaceIDs = append(interfaceIDs, aws.ToString(netInterface.NetworkInterfaceId))
+			}
+		}
+	}
+	return volumeIDs, interfaceIDs, nil
+}
+
+// remainingVolumes returns the volumes among volumeIDs that still exist and
+// are not being deleted.
+func remainingVolumes(ctx context.Context, ec2Svc *ec2.Client, volumeIDs []string) ([]types.Volume, error) {
+	if len(volumeIDs) == 0 {
+		return nil, nil
+	}
+
+	// Filtering by ID instead of passing the IDs does not fail on the
+	// volumes that are already gone.
+	output, err := ec2Svc.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("volume-id"),
+				Values: volumeIDs,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe volumes: %w", err)
+	}
+
+	volumes := []types.Volume{}
+	for _, volume := range output.Volumes {
+		if volume.State != types.VolumeStateDeleting && volume.State != types.VolumeStateDeleted {
+			volumes = append(volumes, volume)
+		}
+	}
+	return volumes, nil
+}
+
+// remainingInterfaces returns the network interfaces among interfaceIDs that still exist.
+func remainingInterfaces(ctx context.Context, ec2Svc *ec2.Client, interfaceIDs []string) ([]types.NetworkInterface, error) {
+	if len(interfaceIDs) == 0 {
+		return nil, nil
+	}
+
+	output, err := ec2Svc.DescribeNetworkInterfaces(ctx, &ec2.DescribeNetworkInterfacesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("network-interface-id"),
+				Values: interfaceIDs,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe network interfaces: %w", err)
+	}
+	return output.NetworkInterfaces, nil
+}
@@ -0,0 +1,258 @@
+// Package provider defines the interface tailout uses to manage exit node
+// instances on a cloud provider, and a registry of the available backends.
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultProvider is the name of the provider used when none is configured.
+const DefaultProvider = "aws"
+
+// Purchasing options of an instance.
+const (
+	MarketSpot             = "spot"
+	MarketOnDemand         = "on-demand"
+	MarketSpotThenOnDemand = "spot-then-on-demand"
+)
+
+// Bootstrap strategies, that is how Tailscale is installed on an instance.
+const (
+	// BootstrapSSM installs Tailscale with a command run by the SSM agent
+	// once the instance is running.
+	BootstrapSSM = "ssm"
+	// BootstrapUserData installs Tailscale from the user data of the
+	// instance, which joins the tailnet on its own.
+	BootstrapUserData = "user-data"
+)
+
+// Tags recorded on tailout instances.
+const (
+	// TagExpiresAt holds the RFC 3339 time at which the instance shuts itself down.
+	TagExpiresAt = "tailout:expires-at"
+	// TagCreatedBy holds the user and machine the instance was created from.
+	TagCreatedBy = "tailout:created-by"
+	// TagShutdown holds the shutdown duration the instance was created with.
+	TagShutdown = "tailout:shutdown"
+	// TagMarket holds the purchasing option the instance was launched with,
+	// MarketSpot or MarketOnDemand.
+	TagMarket = "tailout:market"
+	// TagNetwork holds the network the instance was created in.
+	TagNetwork = "tailout:network"
+	// TagImageBuilder is set to "true" on the instances that build tailout images.
+	TagImageBuilder = "tailout:image-builder"
+	// TagPersistent is set to "true" on instances that never shut themselves down.
+	TagPersistent = "tailout:persistent"
+	// TagAuthKey holds the ID of the auth key the instance joins the tailnet with.
+	TagAuthKey = "tailout:auth-key-id"
+)
+
+// EgressEchoPort is the tailnet port on which nodes serve, over plain HTTP,
+// the public IP address their traffic leaves the cloud with.
+const EgressEchoPort = "8080"
+
+// ErrSpotUnavailable is returned by Launch when a spot instance cannot be
+// launched because of missing capacity or a max price that is too low.
+var ErrSpotUnavailable = errors.New("spot capacity unavailable")
+
+// ErrRescheduleUnavailable is returned by Reschedule when commands cannot be
+// run on the instance, for example when it was bootstrapped from its user
+// data in an account where SSM is not set up.
+var ErrRescheduleUnavailable = errors.New("cannot run commands on the instance")
+
+// Provider is implemented by every cloud backend tailout can create exit nodes on.
+type Provider interface {
+	// Name returns the name the provider is registered with.
+	Name() string
+	// Regions returns the sorted list of regions available to the caller.
+	Regions(ctx context.Context) ([]string, error)
+	// Account returns the identifier of the account resources are created in.
+	Account(ctx context.Context, region string) (string, error)
+	// LookupImage returns the image instances of the given type should be
+	// launched from, matching the architecture of the instance type. The
+	// newest tailout image is preferred when one was built.
+	LookupImage(ctx context.Context, region, instanceType string) (Image, error)
+	// BuildImage creates a tailout image with Tailscale installed for the
+	// architecture of the instance type, from a temporary builder instance.
+	BuildImage(ctx context.Context, req ImageBuildRequest) (Image, error)
+	// Images returns the tailout images of a region, newest first.
+	Images(ctx context.Context, region string) ([]Image, error)
+	// DeleteImage deletes a tailout image along with its snapshots.
+	DeleteImage(ctx context.Context, region, imageID string) error
+	// Launch starts a new instance and waits for it to be running. In dry run
+	// mode it returns a zero Instance once the request has been validated.
+	Launch(ctx context.Context, req LaunchRequest) (Instance, error)
+	// Bootstrap installs Tailscale on a running instance and joins it to the
+	// tailnet with the auth key of its LaunchRequest.
+	Bootstrap(ctx context.Context, req BootstrapRequest) error
+	// Reschedule replaces the scheduled shutdown of a running instance with
+	// one at deadline, and records the new deadline in the TagExpiresAt tag.
+	// A persistent instance loses its TagPersistent tag. It fails with
+	// ErrRescheduleUnavailable if commands cannot be run on the instance.
+	Reschedule(ctx context.Context, region, instanceID string, deadline time.Time) error
+	// Describe returns the tailout instances of a region. If instanceIDs is
+	// empty, all the instances created by tailout are returned.
+	Describe(ctx context.Context, region string, instanceIDs ...string) ([]Instance, error)
+	// Terminate terminates the given instances, waits for them to be
+	// terminated and deletes the volumes and network interfaces they leave
+	// behind, along with the dedicated network of the region once no instance
+	// uses it. It returns the IDs of the removed resources, and fails if any
+	// tailout resource is left behind. In dry run mode nothing is removed and the IDs of
+	// the resources that would be removed are returned.
+	Terminate(ctx context.Context, region string, instanceIDs []string, dryRun bool) ([]string, error)
+	// ReleaseNetwork deletes the dedicated network tailout created in the
+	// region once no instance uses it anymore, and returns the IDs of the
+	// deleted resources. In dry run mode nothing is deleted and the IDs of the
+	// resources that would be deleted are returned.
+	ReleaseNetwork(ctx context.Context, region string, dryRun bool) ([]string, error)
+}
+
+// Image is a machine image instances can be launched from.
+type Image struct {
+	ID           string
+	Name         string
+	Owner        string
+	Architecture string
+	Created      time.Time
+}
+
+// ImageBuildRequest holds the parameters of a new tailout image.
+type ImageBuildRequest struct {
+	Region string
+	// InstanceType is the type of the builder instance, which selects the
+	// architecture of the image.
+	InstanceType string
+	// Network is where the builder instance is launched.
+	Network Network
+	// Progress, if set, is called with a short description of the current step.
+	Progress func(string)
+}
+
+// Instance is a cloud instance created by tailout.
+type Instance struct {
+	ID           string
+	Name         string
+	Region       string
+	PublicIP     string
+	State        string
+	InstanceType string
+	Market       string
+	LaunchTime   time.Time
+	Tags         map[string]string
+}
+
+// LaunchRequest holds the parameters of a new instance.
+type LaunchRequest struct {
+	Region       string
+	Image        Image
+	InstanceType string
+	Network      Network
+	// Market is either MarketSpot or MarketOnDemand.
+	Market string
+	// SpotMaxPrice is the maximum hourly price of a spot instance. If empty,
+	// the on-demand price is used as the maximum.
+	SpotMaxPrice string
+	// ShutdownAfter is the number of minutes after which the instance shuts
+	// itself down. If empty, no shutdown is scheduled.
+	ShutdownAfter string
+	// Hostname is the tailnet hostname of the instance, also recorded as its
+	// name. If empty, it is derived from the region and the instance ID.
+	Hostname string
+	// AuthKey is the key the instance joins the tailnet with. It is handed
+	// to the instance through its user data, only readable with IMDSv2.
+	AuthKey string
+	// Bootstrap is the strategy Tailscale is installed with. With
+	// BootstrapUserData, the instance installs Tailscale and joins the
+	// tailnet on its own.
+	Bootstrap string
+	// Tags are recorded on the instance in addition to the App=tailout tag.
+	Tags   map[string]string
+	DryRun bool
+	// Progress, if set, is called with a short description of the current step.
+	Progress func(string)
+}
+
+// Network selects where an instance is launched. The zero value launches the
+// instance in the default network of the region.
+type Network struct {
+	VPCID            string
+	SubnetID         string
+	SecurityGroupIDs []string
+	// Dedicated launches the instance in a network created and owned by
+	// tailout, which is reused by the next instances of the region.
+	Dedicated bool
+}
+
+// BootstrapRequest holds the parameters needed to join an instance to the tailnet.
+type BootstrapRequest struct {
+	Region     string
+	InstanceID string
+	Hostname   string
+	// Progress, if set, is called with a short description of the current step.
+	Progress func(string)
+}
+
+// Factory creates a new Provider.
+type Factory func() (Provider, error)
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{}
+)
+
+// Register makes a provider available under the given name. It panics if a
+// provider with the same name is already registered.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("provider %s already registered", name))
+	}
+	factories[name] = factory
+}
+
+// New returns the provider registered under name, or the default provider if name is empty.
+func New(name string) (Provider, error) {
+	if name == "" {
+		name = DefaultProvider
+	}
+
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q, available providers: %v", name, Names())
+	}
+
+	p, err := factory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create provider %s: %w", name, err)
+	}
+	return p, nil
+}
+
+// Names returns the sorted names of the registered providers.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Report calls progress with msg if progress is set.
+func Report(progress func(string), msg string) {
+	if progress != nil {
+		progress(msg)
+	}
+}
@@ -0,0 +1,247 @@
+package tailout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lucacome/tailout/internal"
+	"tailscale.com/net/socks5"
+)
+
+// Proxy starts local SOCKS5 and HTTP proxies whose connections egress through
+// a tailout node. The connections are dialed by the embedded node, which uses
+// the node as its exit node, so the exit node of this machine is untouched.
+func (app *App) Proxy(ctx context.Context, args []string) error {
+	socksAddress := app.Config.Proxy.SocksAddress
+	httpAddress := app.Config.Proxy.HTTPAddress
+	if socksAddress == "" && httpAddress == "" {
+		return errors.New("no proxy address specified")
+	}
+
+	apiClient, err := app.tailscaleClient(ctx, scopeDevicesRead)
+	if err != nil {
+		return err
+	}
+
+	app.Config.Embedded.Enabled = true
+	localClient, stopEmbedded, err := app.localClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopEmbedded()
+
+	var node internal.Node
+	if len(args) != 0 {
+		node, err = app.selectNode(ctx, apiClient, args[0])
+		if err != nil {
+			return err
+		}
+	} else {
+		nodes, listErr := app.inventory(ctx, apiClient)
+		if listErr != nil {
+			return listErr
+		}
+		devices := internal.OnlineDevices(nodes)
+		if len(devices) == 0 {
+			return errors.New("no tailout node found in your tailnet")
+		}
+		device, bestErr := bestDevice(ctx, localClient, devices)
+		if bestErr != nil {
+			return bestErr
+		}
+		node = internal.Node{Device: &device}
+	}
+
+	acceptDNS := true
+	err = internal.UpdateExitNode(ctx, localClient, apiClient, node.Device.NodeID, internal.ExitNodeOptions{
+		AcceptDNS: &acceptDNS,
+		SkipState: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to use %s as exit node: %w", node.Hostname(), err)
+	}
+
+	dial := app.embedded.Dial
+	var (
+		wg      sync.WaitGroup
+		servers []io.Closer
+	)
+	defer func() {
+		for _, server := range servers {
+			server.Close()
+		}
+		wg.Wait()
+	}()
+
+	fmt.Printf("Proxying through %s, press Ctrl-C to stop.\n", node.Hostname())
+	if socksAddress != "" {
+		listener, listenErr := net.Listen("tcp", socksAddress)
+		if listenErr != nil {
+			return fmt.Errorf("failed to listen on %s: %w", socksAddress, listenErr)
+		}
+		servers = append(servers, listener)
+
+		socksServer := &socks5.Server{
+			Logf: func(format string, args ...any) {
+				slog.Debug(fmt.Sprintf(format, args...))
+			},
+			Dialer: dial,
+		}
+		wg.Go(func() {
+			if serveErr := socksServer.Serve(listener); serveErr != nil && !errors.Is(serveErr, net.ErrClosed) {
+				slog.Error("SOCKS5 proxy failed", "error", serveErr)
+			}
+		})
+
+		fmt.Println("SOCKS5 proxy: socks5h://" + listener.Addr().String())
+		fmt.Println("  export ALL_PROXY=socks5h://" + listener.Addr().String())
+	}
+	if httpAddress != "" {
+		listener, listenErr := net.Listen("tcp", httpAddress)
+		if listenErr != nil {
+			return fmt.Errorf("failed to listen on %s: %w", httpAddress, listenErr)
+		}
+
+		httpServer := &http.Server{
+			Handler:           newHTTPProxy(dial),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		servers = append(servers, httpServer)
+		wg.Go(func() {
+			if serveErr := httpServer.Serve(listener); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+				slog.Error("HTTP proxy failed", "error", serveErr)
+			}
+		})
+
+		fmt.Println("HTTP proxy: http://" + listener.Addr().String())
+		fmt.Println("  export HTTP_PROXY=http://" + listener.Addr().String() + " HTTPS_PROXY=http://" + listener.Addr().String())
+	}
+
+	<-ctx.Done()
+	fmt.Println("Stopping the proxy...")
+	return nil
+}
+
+// httpProxy is an HTTP proxy that tunnels CONNECT requests and forwards the
+// other requests, over connections opened by dial.
+type httpProxy struct {
+	dial      func(ctx context.Context, network, address string) (net.Conn, error)
+	transport *http.Transport
+}
+
+func newHTTPProxy(dial func(ctx context.Context, network, address string) (net.Conn, error)) *httpProxy {
+	return &httpProxy{
+		dial: dial,
+		transport: &http.Transport{
+			DialContext:         dial,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// hopHeaders are the headers that only apply to the connection to the proxy.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Keep-Alive",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+
+	if r.URL.Host == "" {
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	for _, header := range hopHeaders {
+		outReq.Header.Del(header)
+	}
+
+	resp, err := p.transport.RoundTrip(outReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range hopHeaders {
+		resp.Header.Del(header)
+	}
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		slog.Debug("failed to copy response", "url", r.URL.String(), "error", err)
+	}
+}
+
+// tunnel connects the client to the host of the CONNECT request.
+func (p *httpProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		slog.Debug("failed to hijack connection", "error", err)
+		return
+	}
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	// Bytes the client sent after the request are already buffered.
+	if n := buf.Reader.Buffered(); n > 0 {
+		buffered, _ := buf.Reader.Peek(n)
+		if _, err := upstream.Write(buffered); err != nil {
+			client.Close()
+			upstream.Close()
+			return
+		}
+	}
+
+	go func() {
+		defer upstream.Close()
+		defer client.Close()
+		_, _ = io.Copy(upstream, client)
+	}()
+	defer upstream.Close()
+	defer client.Close()
+	_, _ = io.Copy(client, upstream)
+}
@@ -3,46 +3,52 @@ package tailout
 import (
 	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"net/netip"
-	"net/url"
 	"slices"
 
 	"github.com/lucacome/tailout/internal"
-	tslocal "tailscale.com/client/local"
-	tsapi "tailscale.com/client/tailscale/v2"
 )
 
 func (app *App) Status(ctx context.Context) error {
-	baseURL, err := url.Parse(app.Config.Tailscale.BaseURL)
+	localClient, stopEmbedded, err := app.localClient(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to parse base URL: %w", err)
+		return err
 	}
+	defer stopEmbedded()
 
-	var localClient tslocal.Client
 	status, err := localClient.Status(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get tailscale status: %w", err)
 	}
 
-	client := &tsapi.Client{
-		APIKey:  app.Config.Tailscale.APIKey,
-		BaseURL: baseURL,
+	client, err := app.tailscaleClient(ctx, scopeDevicesRead)
+	if err != nil {
+		return err
 	}
 
-	nodes, err := internal.GetActiveNodes(ctx, client)
+	nodes, err := app.inventory(ctx, client)
 	if err != nil {
-		return fmt.Errorf("failed to get active nodes: %w", err)
+		return err
+	}
+	if !app.Config.Status.IncludeBuilders {
+		nodes = internal.WithoutImageBuilders(nodes)
 	}
 
-	var currentNode tsapi.Device
+	var currentNode internal.Node
 
 	if status.ExitNodeStatus != nil {
-		i := slices.IndexFunc(nodes, func(e tsapi.Device) bool {
-			return netip.MustParsePrefix(e.Addresses[0]+"/32") == status.ExitNodeStatus.TailscaleIPs[0]
+		i := slices.IndexFunc(nodes, func(e internal.Node) bool {
+			addr, err := netip.ParseAddr(e.Address())
+			if err != nil {
+				return false
+			}
+			return slices.ContainsFunc(status.ExitNodeStatus.TailscaleIPs, func(prefix netip.Prefix) bool {
+				return prefix.Addr() == addr
+			})
 		})
-		currentNode = nodes[i]
+		if i != -1 {
+			currentNode = nodes[i]
+		}
 	}
 
 	if len(nodes) == 0 {
@@ -50,31 +56,28 @@ func (app *App) Status(ctx context.Context) error {
 	} else {
 		fmt.Println("Active nodes created by tailout:")
 		for _, node := range nodes {
-			if currentNode.Hostname == node.Hostname {
-				fmt.Println("-", node.Hostname, "[Connected]")
+			label := nodeLabel(node)
+			if createdBy := node.CreatedBy(); createdBy != "" {
+				label += " created by " + createdBy
+			}
+			if currentNode.Hostname() == node.Hostname() {
+				fmt.Println("-", label, "[Connected]")
 			} else {
-				fmt.Println("-", node.Hostname)
+				fmt.Println("-", label)
 			}
 		}
 	}
 
-	// Query for the public IP address of this Node
-	httpClient := &http.Client{}
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://ifconfig.me/ip", nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	if currentNode.Device != nil {
+		return app.verifyEgress(ctx, currentNode)
 	}
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to get public IP: %w", err)
-	}
-	defer resp.Body.Close()
 
-	ipAddr, err := io.ReadAll(resp.Body)
+	// Query for the public IP address of this Node
+	ipAddr, err := app.publicIP(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get public IP: %w", err)
+		return err
 	}
 
-	fmt.Println("Public IP: " + string(ipAddr))
+	fmt.Println("Public IP: " + ipAddr)
 	return nil
 }
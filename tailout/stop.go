@@ -4,15 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/url"
-	"regexp"
 	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/charmbracelet/huh"
 	"github.com/lucacome/tailout/internal"
+	"github.com/lucacome/tailout/tailout/provider"
 	tsapi "tailscale.com/client/tailscale/v2"
 )
 
@@ -20,39 +17,50 @@ func (app *App) Stop(ctx context.Context, args []string) error {
 	nonInteractive := app.Config.NonInteractive
 	dryRun := app.Config.DryRun
 	stopAll := app.Config.Stop.All
+	stopExpired := app.Config.Stop.Expired
+	includePersistent := app.Config.Stop.IncludePersistent
+	includeBuilders := app.Config.Stop.IncludeBuilders
+
+	switch {
+	case stopAll && stopExpired:
+		return errors.New("--all cannot be combined with --expired")
+	case len(args) > 0 && (stopAll || stopExpired):
+		return errors.New("nodes to stop cannot be combined with --all or --expired")
+	}
 
-	nodesToStop := []tsapi.Device{}
+	nodesToStop := []internal.Node{}
 
-	baseURL, err := url.Parse(app.Config.Tailscale.BaseURL)
+	client, err := app.tailscaleClient(ctx, scopeDevices, scopeAuthKeys)
 	if err != nil {
-		return fmt.Errorf("failed to parse base URL: %w", err)
+		return err
 	}
 
-	client := &tsapi.Client{
-		APIKey:  app.Config.Tailscale.APIKey,
-		BaseURL: baseURL,
+	p, err := app.cloudProvider()
+	if err != nil {
+		return err
 	}
 
-	tailoutNodes, err := internal.GetActiveNodes(ctx, client)
+	// A partial inventory is not enough to decide what to delete, a device
+	// whose instance could not be listed would be taken for an orphan.
+	tailoutNodes, err := internal.GetInventory(ctx, p, client)
 	if err != nil {
-		return fmt.Errorf("failed to get active nodes: %w", err)
+		return fmt.Errorf("failed to get tailout nodes: %w", err)
+	}
+	// Terminating a builder makes its image build fail.
+	if !includeBuilders {
+		tailoutNodes = internal.WithoutImageBuilders(tailoutNodes)
 	}
 
-	if len(tailoutNodes) == 0 {
+	if len(tailoutNodes) == 0 && len(args) == 0 {
 		fmt.Println("No tailout node found in your tailnet")
 		return nil
 	}
 
-	if len(args) == 0 && !nonInteractive && !stopAll {
+	if len(args) == 0 && !nonInteractive && !stopAll && !stopExpired {
 		// Create options for multi-select with huh
 		options := make([]huh.Option[int], len(tailoutNodes))
 		for i, node := range tailoutNodes {
-			addr := ""
-			if len(node.Addresses) > 0 {
-				addr = node.Addresses[0]
-			}
-			label := fmt.Sprintf("%s (%s)", node.Hostname, addr)
-			options[i] = huh.NewOption(label, i)
+			options[i] = huh.NewOption(nodeLabel(node), i)
 		}
 
 		var selectedIndices []int
@@ -76,21 +84,32 @@ func (app *App) Stop(ctx context.Context, args []string) error {
 			return nil
 		}
 
-		nodesToStop = make([]tsapi.Device, 0, len(selectedIndices))
+		nodesToStop = make([]internal.Node, 0, len(selectedIndices))
 		for _, idx := range selectedIndices {
 			nodesToStop = append(nodesToStop, tailoutNodes[idx])
 		}
 	} else {
-		if !stopAll {
+		switch {
+		case stopAll:
+			for _, node := range tailoutNodes {
+				if node.Persistent() && !includePersistent {
+					fmt.Println("Skipping persistent node", node.Hostname()+", use --include-persistent to stop it")
+					continue
+				}
+				nodesToStop = append(nodesToStop, node)
+			}
+		case stopExpired:
+			now := time.Now()
 			for _, node := range tailoutNodes {
-				for _, arg := range args {
-					if node.Hostname == arg {
-						nodesToStop = append(nodesToStop, node)
-					}
+				if node.Expired(now) {
+					nodesToStop = append(nodesToStop, node)
 				}
 			}
-		} else {
-			nodesToStop = tailoutNodes
+		default:
+			nodesToStop, err = internal.SelectNodes(tailoutNodes, args)
+			if err != nil {
+				return fmt.Errorf("failed to select nodes: %w", err)
+			}
 		}
 	}
 
@@ -102,7 +121,7 @@ func (app *App) Stop(ctx context.Context, args []string) error {
 	if !nonInteractive {
 		fmt.Println("The following nodes will be stopped:")
 		for _, node := range nodesToStop {
-			fmt.Println("-", node.Hostname)
+			fmt.Println("-", nodeLabel(node))
 		}
 
 		result, err := internal.PromptYesNo(ctx, "Are you sure you want to stop these Nodes?")
@@ -116,53 +135,103 @@ func (app *App) Stop(ctx context.Context, args []string) error {
 		}
 	}
 
-	// TODO: cleanup tailout instances that were not last seen recently
-	// TODO: warning when stopping a device to which you are connected, propose to disconnect before
-	for _, node := range nodesToStop {
-		fmt.Println("Stopping", node.Hostname)
-
-		regionNames, err := internal.GetRegions(ctx)
+	var keys []tsapi.Key
+	if !dryRun {
+		keys, err = client.Keys().List(ctx, false)
 		if err != nil {
-			return fmt.Errorf("failed to retrieve regions: %w", err)
+			return fmt.Errorf("failed to list auth keys: %w", err)
 		}
-		var region string
-		for _, regionName := range regionNames {
-			if strings.Contains(node.Hostname, regionName) {
-				region = regionName
+	}
+
+	// TODO: warning when stopping a device to which you are connected, propose to disconnect before
+	for _, node := range nodesToStop {
+		fmt.Println("Stopping", node.Hostname())
+
+		if node.Instance != nil {
+			removed, terminateErr := p.Terminate(ctx, node.Region(), []string{node.InstanceID()}, dryRun)
+			if len(removed) > 0 && dryRun {
+				fmt.Println("Dry run, would remove", strings.Join(removed, ", "))
+			} else if len(removed) > 0 {
+				fmt.Println("Successfully removed", strings.Join(removed, ", "))
+			}
+			if terminateErr != nil {
+				return fmt.Errorf("failed to terminate instance: %w", terminateErr)
 			}
 		}
 
-		if region == "" {
-			return errors.New("failed to extract region from node name")
+		if dryRun {
+			fmt.Println("Dry run, not deleting node", node.Hostname(), "from the tailnet")
+			continue
 		}
 
-		// Create a session to share configuration, and load external configuration.
-		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
-		if err != nil {
-			return fmt.Errorf("unable to load SDK config: %w", err)
+		if node.Device != nil {
+			err = client.Devices().Delete(ctx, node.Device.ID)
+			if err != nil {
+				return fmt.Errorf("failed to delete node from tailnet: %w", err)
+			}
+
+			fmt.Println("Successfully deleted node", node.Hostname())
 		}
 
-		ec2Svc := ec2.NewFromConfig(cfg)
+		// Auth keys are revoked once the node joined, but an interrupted
+		// create can leave the key of the node behind.
+		for _, key := range keys {
+			if !isNodeKey(node, key) {
+				continue
+			}
+			err = client.Keys().Delete(ctx, key.ID)
+			switch {
+			case tsapi.IsNotFound(err):
+			case err != nil:
+				return fmt.Errorf("failed to revoke auth key: %w", err)
+			default:
+				fmt.Println("Successfully revoked auth key", key.ID)
+			}
+		}
+	}
 
-		// Extract the instance ID from the Node name with a regex
-		instanceID := regexp.MustCompile(`i\-[a-z0-9]{17}$`).FindString(node.Hostname)
+	return nil
+}
 
-		_, err = ec2Svc.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
-			DryRun:      aws.Bool(dryRun),
-			InstanceIds: []string{instanceID},
-		})
-		if err != nil {
-			return fmt.Errorf("failed to terminate instance: %w", err)
-		}
+// nodeLabel returns a short description of a tailout node, used in prompts and listings.
+func nodeLabel(node internal.Node) string {
+	switch {
+	case node.Instance == nil:
+		return fmt.Sprintf("%s (%s, no instance)", node.Hostname(), node.Address())
+	case node.Device == nil:
+		return fmt.Sprintf("%s (%s, %s, not in tailnet%s)", node.Hostname(), node.Region(), node.Instance.State, expiryLabel(node))
+	default:
+		return fmt.Sprintf("%s (%s, %s%s)", node.Hostname(), node.Region(), node.Address(), expiryLabel(node))
+	}
+}
 
-		fmt.Println("Successfully terminated instance", node.Hostname)
+// isNodeKey reports whether the auth key is the one the node joined with,
+// recorded on its instance, or one described with its hostname.
+func isNodeKey(node internal.Node, key tsapi.Key) bool {
+	if node.Instance != nil && node.Instance.Tags[provider.TagAuthKey] != "" {
+		return key.ID == node.Instance.Tags[provider.TagAuthKey]
+	}
+	return key.Description == node.Hostname()
+}
 
-		err = client.Devices().Delete(ctx, node.ID)
-		if err != nil {
-			return fmt.Errorf("failed to delete node from tailnet: %w", err)
-		}
+// expiryLabel describes when the node shuts down, if known.
+func expiryLabel(node internal.Node) string {
+	if node.Persistent() {
+		return ", persistent"
+	}
+	expiresAt, ok := node.ExpiresAt()
+	if !ok {
+		return ""
+	}
+	return ", " + timeRemaining(expiresAt, time.Now())
+}
 
-		fmt.Println("Successfully deleted node", node.Hostname)
+// timeRemaining describes how long is left before the deadline, or how long
+// ago it passed.
+func timeRemaining(expiresAt, now time.Time) string {
+	remaining := expiresAt.Sub(now).Round(time.Minute)
+	if remaining < 0 {
+		return fmt.Sprintf("expired %s ago", -remaining)
 	}
-	return nil
+	return fmt.Sprintf("shuts down in %s (at %s)", remaining, expiresAt.Local().Format(time.DateTime))
 }
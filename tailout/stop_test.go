@@ -0,0 +1,27 @@
+package tailout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeRemaining(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 3, 10, 14, 30, 0, 0, time.UTC)
+	at := func(d time.Duration) string {
+		return " (at " + now.Add(d).Local().Format(time.DateTime) + ")"
+	}
+
+	tests := map[time.Duration]string{
+		90 * time.Minute:                "shuts down in 1h30m0s" + at(90*time.Minute),
+		10*time.Minute + 40*time.Second: "shuts down in 11m0s" + at(10*time.Minute+40*time.Second),
+		20 * time.Second:                "shuts down in 0s" + at(20*time.Second),
+		-2 * time.Hour:                  "expired 2h0m0s ago",
+	}
+	for remaining, want := range tests {
+		if got := timeRemaining(now.Add(remaining), now); got != want {
+			t.Errorf("timeRemaining(now + %s) = %q, want %q", remaining, got, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package tailout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	tsapi "tailscale.com/client/tailscale/v2"
+)
+
+// Scopes of the Tailscale OAuth client needed by the commands.
+const (
+	scopeDevicesRead = "devices:core:read"
+	scopeDevices     = "devices:core"
+	scopeRoutes      = "devices:routes"
+	scopeAuthKeys    = "auth_keys"
+	scopePolicyFile  = "policy_file"
+)
+
+// tailscaleClient returns a client of the Tailscale API, authenticated with
+// the OAuth client of the configuration or else with its API key. With an
+// OAuth client, a token with the given scopes is requested upfront so that a
+// client missing one of them fails before anything is changed.
+func (app *App) tailscaleClient(ctx context.Context, scopes ...string) (*tsapi.Client, error) {
+	cfg := app.Config.Tailscale
+
+	baseURL, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base URL: %w", err)
+	}
+
+	client := &tsapi.Client{
+		BaseURL: baseURL,
+	}
+
+	switch {
+	case cfg.OAuthClientID != "" && cfg.APIKey != "":
+		return nil, errors.New("the Tailscale API key cannot be combined with an OAuth client")
+	case cfg.OAuthClientID != "" && cfg.OAuthClientSecret == "":
+		return nil, errors.New("no secret configured for the Tailscale OAuth client")
+	case cfg.OAuthClientID != "":
+		oauthConfig := clientcredentials.Config{
+			ClientID:     cfg.OAuthClientID,
+			ClientSecret: cfg.OAuthClientSecret,
+			Scopes:       scopes,
+			TokenURL:     baseURL.JoinPath("/api/v2/oauth/token").String(),
+		}
+
+		token, tokenErr := oauthConfig.Token(ctx)
+		if tokenErr != nil {
+			return nil, fmt.Errorf("failed to authenticate with the Tailscale OAuth client, check that it has the %s scopes: %w", strings.Join(scopes, ", "), tokenErr)
+		}
+
+		// The token source outlives ctx, as it refreshes the token when it expires.
+		client.HTTP = &http.Client{
+			Transport: &oauth2.Transport{
+				Source: oauth2.ReuseTokenSource(token, oauthConfig.TokenSource(context.WithoutCancel(ctx))),
+			},
+			Timeout: time.Minute,
+		}
+	case cfg.APIKey != "":
+		client.APIKey = cfg.APIKey
+	default:
+		return nil, errors.New("no Tailscale credentials configured, set an API key or an OAuth client")
+	}
+
+	return client, nil
+}
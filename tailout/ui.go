@@ -2,31 +2,79 @@ package tailout
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"html"
 	"log/slog"
 	"net/http"
-	"net/url"
+	"sync"
 	"time"
 
 	"github.com/lucacome/tailout/internal"
 	"github.com/lucacome/tailout/internal/views"
-	tsapi "tailscale.com/client/tailscale/v2"
 
 	"github.com/a-h/templ"
 )
 
+// uiInventoryTTL is how long the UI shows the same inventory, as every open
+// page polls the status every few seconds.
+const uiInventoryTTL = 30 * time.Second
+
+// inventoryCache keeps the inventory listed for the UI for a short time.
+type inventoryCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	list    func(context.Context) ([]internal.Node, error)
+	nodes   []internal.Node
+	updated time.Time
+}
+
+// get returns the cached inventory, or lists it again if it is older than
+// the TTL. Concurrent callers wait for the same listing.
+func (c *inventoryCache) get(ctx context.Context) ([]internal.Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.updated.IsZero() && time.Since(c.updated) < c.ttl {
+		return c.nodes, nil
+	}
+
+	nodes, err := c.list(ctx)
+	if errors.Is(err, internal.ErrPartialInventory) {
+		slog.Warn("showing a partial inventory", "error", err)
+	} else if err != nil {
+		return nil, err
+	}
+	c.nodes, c.updated = nodes, time.Now()
+	return nodes, nil
+}
+
+// invalidate makes the next get list the inventory again.
+func (c *inventoryCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updated = time.Time{}
+}
+
 func (app *App) UI(ctx context.Context) error {
 	indexComponent := views.Index()
 	app.Config.NonInteractive = true
 
-	baseURL, err := url.Parse(app.Config.Tailscale.BaseURL)
+	client, err := app.tailscaleClient(ctx, scopeDevicesRead)
 	if err != nil {
-		return fmt.Errorf("failed to parse base URL: %w", err)
+		return err
 	}
 
-	client := &tsapi.Client{
-		APIKey:  app.Config.Tailscale.APIKey,
-		BaseURL: baseURL,
+	p, err := app.cloudProvider()
+	if err != nil {
+		return err
+	}
+
+	inventory := &inventoryCache{
+		ttl: uiInventoryTTL,
+		list: func(ctx context.Context) ([]internal.Node, error) {
+			return internal.GetInventory(ctx, p, client)
+		},
 	}
 
 	http.Handle("/", templ.Handler(indexComponent))
@@ -35,6 +83,7 @@ func (app *App) UI(ctx context.Context) error {
 		slog.Info("Creating tailout node")
 		go func() {
 			err := app.Create(ctx)
+			inventory.invalidate()
 			if err != nil {
 				slog.Error("failed to create node", "error", err)
 			}
@@ -47,6 +96,7 @@ func (app *App) UI(ctx context.Context) error {
 		app.Config.Stop.All = true
 		go func() {
 			err := app.Stop(ctx, nil)
+			inventory.invalidate()
 			if err != nil {
 				slog.Error("failed to stop nodes", "error", err)
 			}
@@ -55,15 +105,30 @@ func (app *App) UI(ctx context.Context) error {
 	})
 
 	http.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
-		nodes, err := internal.GetActiveNodes(ctx, client)
+		nodes, err := inventory.get(ctx)
 		if err != nil {
-			slog.Error("failed to get active nodes", "error", err)
+			slog.Error("failed to get tailout nodes", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		nodes = internal.WithoutImageBuilders(nodes)
 		table := ""
 		for _, node := range nodes {
-			table += fmt.Sprintf("<tr class=\"bg-white border-b\"><td class=\"px-4 py-2\">%s</td><td class=\"px-4 py-2\">%s</td><td class=\"px-4 py-2\">%s</td></tr>", node.Hostname, node.Addresses[0], node.LastSeen)
+			lastSeen := ""
+			switch {
+			case node.Device != nil && node.Device.ConnectedToControl:
+				lastSeen = "Connected"
+			case node.Device != nil && node.Device.LastSeen != nil:
+				lastSeen = node.Device.LastSeen.String()
+			}
+			shutdown := ""
+			if node.Persistent() {
+				shutdown = "persistent"
+			} else if expiresAt, ok := node.ExpiresAt(); ok {
+				shutdown = timeRemaining(expiresAt, time.Now())
+			}
+			table += fmt.Sprintf("<tr class=\"bg-white border-b\"><td class=\"px-4 py-2\">%s</td><td class=\"px-4 py-2\">%s</td><td class=\"px-4 py-2\">%s</td><td class=\"px-4 py-2\">%s</td><td class=\"px-4 py-2\">%s</td><td class=\"px-4 py-2\">%s</td></tr>",
+				html.EscapeString(node.Hostname()), node.Region(), node.Address(), node.PublicIP(), lastSeen, shutdown)
 		}
 		if _, err := w.Write([]byte(table)); err != nil {
 			slog.Error("failed to write response", "error", err)
@@ -0,0 +1,143 @@
+package tailout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/lucacome/tailout/internal"
+	tslocal "tailscale.com/client/local"
+	tsapi "tailscale.com/client/tailscale/v2"
+)
+
+const defaultWatchInterval = 10 * time.Second
+
+// watchExitNode polls the local Tailscale status and fails over to another
+// healthy tailout node when the current exit node goes offline, until the
+// context is canceled. It then disconnects from the exit node.
+func (app *App) watchExitNode(ctx context.Context, localClient *tslocal.Client, apiClient *tsapi.Client) error {
+	interval := app.Config.Connect.WatchInterval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	slog.Info("Watching exit node, press Ctrl-C to disconnect", "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if app.embedded != nil {
+				slog.Info("Stopping the watch and the embedded node")
+				return nil
+			}
+			slog.Info("Stopping the watch and disconnecting from the exit node")
+			return app.disconnect(context.WithoutCancel(ctx), localClient, apiClient)
+		case <-ticker.C:
+		}
+
+		status, err := localClient.Status(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("failed to get tailscale status", "error", err)
+			}
+			continue
+		}
+		if status.ExitNodeStatus == nil {
+			slog.Info("Exit node was cleared, stopping the watch")
+			return nil
+		}
+		if status.ExitNodeStatus.Online {
+			continue
+		}
+
+		current := string(status.ExitNodeStatus.ID)
+		slog.Warn("Exit node is offline, failing over", "node", current)
+
+		device, err := app.failover(ctx, localClient, apiClient, current)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("failed to fail over", "error", err)
+			}
+			continue
+		}
+		slog.Info("Switched exit node", "from", current, "to", device.Hostname)
+	}
+}
+
+// failover connects to the healthy tailout node with the lowest latency other
+// than the current one. If there is none and watch_create is set, a node is
+// created in the region given to connect, or in the region of the current one.
+func (app *App) failover(ctx context.Context, localClient *tslocal.Client, apiClient *tsapi.Client, current string) (tsapi.Device, error) {
+	device, err := app.healthyDevice(ctx, localClient, apiClient, current)
+	if errors.Is(err, errNoHealthyNode) && app.Config.Connect.WatchCreate {
+		region := app.Config.Connect.Region
+		if region == "" {
+			region, err = app.nodeRegion(ctx, apiClient, current)
+			if err != nil {
+				return tsapi.Device{}, err
+			}
+		}
+
+		slog.Info("No healthy tailout node left, creating one", "region", region)
+		// Nobody may be there to answer a prompt when the exit node is lost.
+		app.Config.NonInteractive = true
+		app.Config.Region = region
+		app.Config.Create.Connect = false
+		err = app.Create(ctx)
+		if err != nil {
+			return tsapi.Device{}, fmt.Errorf("failed to create node: %w", err)
+		}
+		device, err = app.healthyDevice(ctx, localClient, apiClient, current)
+	}
+	if err != nil {
+		return tsapi.Device{}, err
+	}
+
+	err = internal.UpdateExitNode(ctx, localClient, apiClient, device.NodeID, app.exitNodeOptions())
+	if err != nil {
+		return tsapi.Device{}, fmt.Errorf("failed to connect to %s: %w", device.Hostname, err)
+	}
+	return device, nil
+}
+
+var errNoHealthyNode = errors.New("no healthy tailout node to fail over to")
+
+// healthyDevice returns the reachable tailout device with the lowest latency,
+// excluding the given node.
+func (app *App) healthyDevice(ctx context.Context, localClient *tslocal.Client, apiClient *tsapi.Client, exclude string) (tsapi.Device, error) {
+	nodes, err := app.inventory(ctx, apiClient)
+	if err != nil {
+		return tsapi.Device{}, err
+	}
+
+	candidates := slices.DeleteFunc(internal.OnlineDevices(nodes), func(device tsapi.Device) bool {
+		return device.NodeID == exclude
+	})
+
+	latencies := internal.MeasureLatencies(ctx, localClient, candidates)
+	if len(latencies) == 0 || latencies[0].Err != nil {
+		return tsapi.Device{}, errNoHealthyNode
+	}
+	return latencies[0].Device, nil
+}
+
+// nodeRegion returns the region of the tailout node with the given node ID.
+func (app *App) nodeRegion(ctx context.Context, apiClient *tsapi.Client, nodeID string) (string, error) {
+	nodes, err := app.inventory(ctx, apiClient)
+	if err != nil {
+		return "", err
+	}
+
+	for _, node := range nodes {
+		if node.Device != nil && node.Device.NodeID == nodeID {
+			return node.Region(), nil
+		}
+	}
+	return "", fmt.Errorf("region of node %s not found, use --region", nodeID)
+}